@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// AzureDevOpsWebhookHandler handles git.push service hooks of azure devops repos
+type AzureDevOpsWebhookHandler struct {
+	queue    *RequestWorkqueue
+	username string
+	password string
+	//pathsPolicy decides about resources with path filters, azure devops doesn't send changed files
+	pathsPolicy string
+}
+
+type azureDevOpsPushEvent struct {
+	EventType string `json:"eventType"`
+	Resource  struct {
+		RefUpdates []struct {
+			Name        string `json:"name"`
+			OldObjectID string `json:"oldObjectId"`
+			NewObjectID string `json:"newObjectId"`
+		} `json:"refUpdates"`
+		Repository struct {
+			Name          string `json:"name"`
+			RemoteURL     string `json:"remoteUrl"`
+			SSHURL        string `json:"sshUrl"`
+			DefaultBranch string `json:"defaultBranch"`
+		} `json:"repository"`
+	} `json:"resource"`
+}
+
+func (az *AzureDevOpsWebhookHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if az.username != "" || az.password != "" {
+		username, password, ok := req.BasicAuth()
+		if !ok {
+			signatureRejections.WithLabelValues("azure-devops", "missing").Inc()
+			rw.Header().Set("WWW-Authenticate", `Basic realm="webhook-broadcaster"`)
+			http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+			log.Printf("Rejecting azure devops webhook without basic auth")
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(username), []byte(az.username)) != 1 || !verifyToken(password, []string{az.password}) {
+			signatureRejections.WithLabelValues("azure-devops", "invalid").Inc()
+			http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+			log.Printf("Rejecting azure devops webhook with invalid credentials")
+			return
+		}
+	}
+
+	body, err := readBody(rw, req)
+	if err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to read request body: %s", err)
+		return
+	}
+	var pushEvent azureDevOpsPushEvent
+	err = json.Unmarshal(body, &pushEvent)
+	if err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to parse request body: %s", err)
+		return
+	}
+	repository := pushEvent.Resource.Repository
+
+	if pushEvent.EventType != "git.push" {
+		log.Printf("Ignoring unhandled azure devops event %s for %s", pushEvent.EventType, repository.RemoteURL)
+		rw.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(rw, "ignored: event %s is not handled\n", pushEvent.EventType)
+		return
+	}
+
+	for _, push := range pushEvent.pushEvents() {
+		push.PathsPolicy = az.pathsPolicy
+		BroadcastPush(az.queue, push)
+	}
+}
+
+// parseAzureDevOpsPush returns a push event for every ref update of a git.push payload, deletions are skipped
+func parseAzureDevOpsPush(payload []byte) ([]PushEvent, error) {
+	var pushEvent azureDevOpsPushEvent
+	if err := json.Unmarshal(payload, &pushEvent); err != nil {
+		return nil, err
+	}
+	return pushEvent.pushEvents(), nil
+}
+
+func (pushEvent azureDevOpsPushEvent) pushEvents() []PushEvent {
+	var pushes []PushEvent
+	repository := pushEvent.Resource.Repository
+	for _, refUpdate := range pushEvent.Resource.RefUpdates {
+		if refUpdate.NewObjectID == zeroSHA {
+			log.Printf("Skipping deletion event for ref %s in %s", refUpdate.Name, repository.RemoteURL)
+			continue
+		}
+		log.Printf("Received azure devops webhook for %s, ref %s", repository.RemoteURL, refUpdate.Name)
+		pushes = append(pushes, PushEvent{
+			Provider:       "azure-devops",
+			RepositoryURLs: []string{repository.RemoteURL, repository.SSHURL},
+			Ref:            refUpdate.Name,
+			After:          refUpdate.NewObjectID,
+			DefaultBranch:  strings.TrimPrefix(repository.DefaultBranch, "refs/heads/"),
+			FilesUnknown:   true,
+		})
+	}
+	return pushes
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/concourse/concourse/atc"
+)
+
+func TestAzureDevOpsWebhookHandler(t *testing.T) {
+	withResourceCache(t, Pipeline{
+		ID:   1,
+		Name: "pipeline",
+		Team: "main",
+		Resources: []atc.ResourceConfig{
+			{Name: "main", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "git@ssh.dev.azure.com:v3/org/project/repo"}},
+			{Name: "feature", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://org@dev.azure.com/org/project/_git/repo", "branch": "feature"}},
+		},
+	})
+	body := `{"eventType":"git.push","resource":{
+		"refUpdates":[{"name":"refs/heads/main","newObjectId":"abc"},{"name":"refs/heads/old","newObjectId":"0000000000000000000000000000000000000000"}],
+		"repository":{"name":"repo","remoteUrl":"https://org.visualstudio.com/DefaultCollection/project/_git/repo","defaultBranch":"refs/heads/main"}}}`
+
+	cases := []struct {
+		username string
+		password string
+		body     string
+		Status   int
+		Queued   int
+	}{
+		{"hook", "s3cr3t", body, 200, 1},
+		{"hook", "wrong", body, 401, 0},
+		{"", "", body, 401, 0},
+		{"hook", "s3cr3t", `{"eventType":"git.pullrequest.created"}`, 202, 0},
+	}
+	for nr, c := range cases {
+		handler := &AzureDevOpsWebhookHandler{NewRequestWorkqueue(1), "hook", "s3cr3t", PathsPolicyTrigger}
+		req := httptest.NewRequest("POST", "/azure-devops", strings.NewReader(c.body))
+		if c.username != "" {
+			req.SetBasicAuth(c.username, c.password)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Code != c.Status || handler.queue.queue.Len() != c.Queued {
+			t.Errorf("Test case %d failed. Got %d, %d queued", nr+1, rw.Code, handler.queue.queue.Len())
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// BitbucketCloudWebhookHandler handles webhooks of bitbucket.org
+type BitbucketCloudWebhookHandler struct {
+	queue *RequestWorkqueue
+	//hookUUIDs are the accepted X-Hook-UUID values
+	hookUUIDs []string
+	secrets   *SecretStore
+	//pathsPolicy decides about resources with path filters, bitbucket cloud doesn't send changed files
+	pathsPolicy string
+}
+
+type bitbucketCloudRef struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Target struct {
+		Hash string `json:"hash"`
+	} `json:"target"`
+}
+
+type bitbucketCloudPushEvent struct {
+	Repository struct {
+		FullName string `json:"full_name"`
+		Links    struct {
+			HTML struct {
+				Href string `json:"href"`
+			} `json:"html"`
+		} `json:"links"`
+	} `json:"repository"`
+	Push struct {
+		Changes []struct {
+			New *bitbucketCloudRef `json:"new"`
+			Old *bitbucketCloudRef `json:"old"`
+		} `json:"changes"`
+	} `json:"push"`
+}
+
+func (bb *BitbucketCloudWebhookHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	body, err := readBody(rw, req)
+	if err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to read request body: %s", err)
+		return
+	}
+	var pushEvent bitbucketCloudPushEvent
+	err = json.Unmarshal(body, &pushEvent)
+	if err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to parse request body: %s", err)
+		return
+	}
+
+	if reason := bb.authenticate(req, body, pushEvent.Repository.Links.HTML.Href, pushEvent.Repository.FullName); reason != "" {
+		signatureRejections.WithLabelValues("bitbucket-cloud", reason).Inc()
+		http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+		log.Printf("Rejecting bitbucket cloud webhook for %s: %s hook uuid or signature", pushEvent.Repository.FullName, reason)
+		return
+	}
+
+	event := req.Header.Get("X-Event-Key")
+	if event != "repo:push" {
+		log.Printf("Ignoring unhandled bitbucket cloud event %s for %s", event, pushEvent.Repository.FullName)
+		rw.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(rw, "ignored: event %s is not handled\n", event)
+		return
+	}
+
+	for _, push := range pushEvent.pushEvents() {
+		push.PathsPolicy = bb.pathsPolicy
+		BroadcastPush(bb.queue, push)
+	}
+}
+
+// parseBitbucketCloudPush returns a push event for every changed branch or tag of a repo:push payload, deletions are skipped
+func parseBitbucketCloudPush(payload []byte) ([]PushEvent, error) {
+	var pushEvent bitbucketCloudPushEvent
+	if err := json.Unmarshal(payload, &pushEvent); err != nil {
+		return nil, err
+	}
+	return pushEvent.pushEvents(), nil
+}
+
+func (pushEvent bitbucketCloudPushEvent) pushEvents() []PushEvent {
+	var pushes []PushEvent
+	repositoryURLs := bitbucketCloudCloneURLs(pushEvent.Repository.Links.HTML.Href, pushEvent.Repository.FullName)
+	for _, change := range pushEvent.Push.Changes {
+		if change.New == nil {
+			if change.Old != nil {
+				log.Printf("Skipping deletion event for %s %s in %s", change.Old.Type, change.Old.Name, pushEvent.Repository.FullName)
+			}
+			continue
+		}
+		var ref string
+		switch change.New.Type {
+		case "branch", "named_branch":
+			ref = "refs/heads/" + change.New.Name
+		case "tag", "annotated_tag":
+			ref = "refs/tags/" + change.New.Name
+		default:
+			log.Printf("Skipping change of unknown type %s in %s", change.New.Type, pushEvent.Repository.FullName)
+			continue
+		}
+		log.Printf("Received bitbucket cloud webhook for %s, ref %s", pushEvent.Repository.FullName, ref)
+		pushes = append(pushes, PushEvent{
+			Provider:       "bitbucket-cloud",
+			RepositoryURLs: repositoryURLs,
+			Ref:            ref,
+			After:          change.New.Target.Hash,
+			FilesUnknown:   true,
+		})
+	}
+	return pushes
+}
+
+// authenticate checks the hook uuid or the signature of the request, if configured.
+// The secrets are looked up by the host and full name (workspace/repository) of the repository.
+// It returns the reason of the rejection or an empty string.
+func (bb *BitbucketCloudWebhookHandler) authenticate(req *http.Request, body []byte, htmlURL, fullName string) string {
+	if len(bb.hookUUIDs) == 0 && bb.secrets.Empty() {
+		return ""
+	}
+	if uuid := req.Header.Get("X-Hook-UUID"); uuid != "" && verifyToken(uuid, bb.hookUUIDs) {
+		return ""
+	}
+	signature := req.Header.Get("X-Hub-Signature")
+	host, repository, _ := GitRepositoryIdentity(bitbucketCloudCloneURLs(htmlURL, fullName)[0])
+	if signature != "" && verifySHA256Signature(signature, body, bb.secrets.Secrets(host, repository)) {
+		return ""
+	}
+	if signature == "" && req.Header.Get("X-Hook-UUID") == "" {
+		return "missing"
+	}
+	return "invalid"
+}
+
+// bitbucketCloudCloneURLs returns the https and ssh clone urls of a bitbucket cloud repository
+func bitbucketCloudCloneURLs(htmlURL, fullName string) []string {
+	host := "bitbucket.org"
+	if u, err := url.Parse(htmlURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	return []string{
+		fmt.Sprintf("https://%s/%s.git", host, fullName),
+		fmt.Sprintf("git@%s:%s.git", host, fullName),
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/concourse/concourse/atc"
+)
+
+func TestBitbucketCloudCloneURLs(t *testing.T) {
+	urls := bitbucketCloudCloneURLs("https://bitbucket.org/team/repo", "team/repo")
+	cases := []struct {
+		uri    string
+		Result bool
+	}{
+		{"https://bitbucket.org/team/repo.git", true},
+		{"https://user@bitbucket.org/team/repo.git", true},
+		{"git@bitbucket.org:team/repo.git", true},
+		{"git@bitbucket.org:team/other.git", false},
+	}
+	for nr, c := range cases {
+		if sameRepository(c.uri, urls) != c.Result {
+			t.Errorf("Test case %d failed.", nr+1)
+		}
+	}
+}
+
+func TestBitbucketCloudWebhookHandler(t *testing.T) {
+	withResourceCache(t, Pipeline{
+		ID:   1,
+		Name: "pipeline",
+		Team: "main",
+		Resources: []atc.ResourceConfig{
+			{Name: "master", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "git@bitbucket.org:team/repo.git", "branch": "master"}},
+			{Name: "develop", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://bitbucket.org/team/repo.git", "branch": "develop"}},
+		},
+	})
+	body := `{"repository":{"full_name":"team/repo","links":{"html":{"href":"https://bitbucket.org/team/repo"}}},
+		"push":{"changes":[
+		{"new":{"type":"branch","name":"master"},"old":{"type":"branch","name":"master"}},
+		{"new":{"type":"branch","name":"develop"},"old":null},
+		{"new":null,"old":{"type":"branch","name":"gone"}}]}}`
+
+	cases := []struct {
+		event  string
+		uuid   string
+		Status int
+		Queued int
+	}{
+		{"repo:push", "{hook-uuid}", 200, 2},
+		{"repo:push", "{other}", 401, 0},
+		{"repo:push", "", 401, 0},
+		{"pullrequest:created", "{hook-uuid}", 202, 0},
+	}
+	for nr, c := range cases {
+		handler := &BitbucketCloudWebhookHandler{NewRequestWorkqueue(1), []string{"{hook-uuid}"}, nil, PathsPolicyTrigger}
+		req := httptest.NewRequest("POST", "/bitbucket-cloud", strings.NewReader(body))
+		req.Header.Set("X-Event-Key", c.event)
+		if c.uuid != "" {
+			req.Header.Set("X-Hook-UUID", c.uuid)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Code != c.Status || handler.queue.queue.Len() != c.Queued {
+			t.Errorf("Test case %d failed. Got %d, %d queued", nr+1, rw.Code, handler.queue.queue.Len())
+		}
+	}
+}
+
+func TestBitbucketCloudScopedSecrets(t *testing.T) {
+	withResourceCache(t)
+	secrets, _ := NewSecretStore([]string{"global"}, []string{"bitbucket.org/team/repo=scoped"})
+	body := `{"repository":{"full_name":"team/repo","links":{"html":{"href":"https://bitbucket.org/team/repo"}}},"push":{"changes":[]}}`
+	other := strings.Replace(body, "team/repo", "team/other", -1)
+
+	cases := []struct {
+		body   string
+		secret string
+		Status int
+	}{
+		{body, "scoped", 200},
+		{body, "global", 401},
+		{other, "global", 200},
+		{other, "scoped", 401},
+	}
+	for nr, c := range cases {
+		handler := &BitbucketCloudWebhookHandler{NewRequestWorkqueue(1), nil, secrets, PathsPolicyTrigger}
+		req := httptest.NewRequest("POST", "/bitbucket-cloud", strings.NewReader(c.body))
+		req.Header.Set("X-Event-Key", "repo:push")
+		mac := hmac.New(sha256.New, []byte(c.secret))
+		mac.Write([]byte(c.body))
+		req.Header.Set("X-Hub-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Code != c.Status {
+			t.Errorf("Test case %d failed. Got %d", nr+1, rw.Code)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// BitbucketServerWebhookHandler handles webhooks of Bitbucket Server / Data Center
+type BitbucketServerWebhookHandler struct {
+	queue   *RequestWorkqueue
+	secrets *SecretStore
+	//pathsPolicy decides about resources with path filters, bitbucket server doesn't send changed files
+	pathsPolicy string
+	//baseURL is used to build the clone urls of payloads without clone links, it might be empty
+	baseURL string
+}
+
+type bitbucketServerRefsChangedEvent struct {
+	EventKey   string `json:"eventKey"`
+	Repository struct {
+		Slug    string `json:"slug"`
+		Project struct {
+			Key string `json:"key"`
+		} `json:"project"`
+		Links struct {
+			Clone []struct {
+				Href string `json:"href"`
+				Name string `json:"name"`
+			} `json:"clone"`
+		} `json:"links"`
+	} `json:"repository"`
+	Changes []struct {
+		Ref struct {
+			ID        string `json:"id"`
+			DisplayID string `json:"displayId"`
+			Type      string `json:"type"`
+		} `json:"ref"`
+		RefID    string `json:"refId"`
+		FromHash string `json:"fromHash"`
+		ToHash   string `json:"toHash"`
+		Type     string `json:"type"`
+	} `json:"changes"`
+}
+
+func (bb *BitbucketServerWebhookHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	body, err := readBody(rw, req)
+	if err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to read request body: %s", err)
+		return
+	}
+
+	event := req.Header.Get("X-Event-Key")
+	if event == "diagnostics:ping" {
+		log.Printf("Received bitbucket server ping")
+		fmt.Fprintf(rw, "pong\n")
+		return
+	}
+
+	var refsChanged bitbucketServerRefsChangedEvent
+	err = json.Unmarshal(body, &refsChanged)
+	if err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to parse request body: %s", err)
+		return
+	}
+	repositoryURLs := refsChanged.repositoryURLs(bb.baseURL)
+	repositoryName := refsChanged.repositoryName()
+
+	if !bb.secrets.Empty() {
+		var host string
+		if len(repositoryURLs) > 0 {
+			host, _, _ = GitRepositoryIdentity(repositoryURLs[0])
+		}
+		signature := req.Header.Get("X-Hub-Signature")
+		if signature == "" {
+			signatureRejections.WithLabelValues("bitbucket-server", "missing").Inc()
+			http.Error(rw, "Missing X-Hub-Signature header", http.StatusUnauthorized)
+			log.Printf("Rejecting unsigned bitbucket server webhook for %s", repositoryName)
+			return
+		}
+		if !verifySHA256Signature(signature, body, bb.secrets.Secrets(host, repositoryName)) {
+			signatureRejections.WithLabelValues("bitbucket-server", "invalid").Inc()
+			http.Error(rw, "Invalid signature", http.StatusUnauthorized)
+			log.Printf("Rejecting bitbucket server webhook with invalid signature for %s", repositoryName)
+			return
+		}
+	}
+
+	if event == "" {
+		event = refsChanged.EventKey
+	}
+	if event != "repo:refs_changed" {
+		log.Printf("Ignoring unhandled bitbucket server event %s for %s", event, repositoryName)
+		rw.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(rw, "ignored: event %s is not handled\n", event)
+		return
+	}
+
+	for _, push := range refsChanged.pushEvents(bb.baseURL) {
+		push.PathsPolicy = bb.pathsPolicy
+		BroadcastPush(bb.queue, push)
+	}
+}
+
+// parseBitbucketServerPush returns a push event for every changed ref of a repo:refs_changed payload, deletions are skipped
+func parseBitbucketServerPush(payload []byte) ([]PushEvent, error) {
+	var refsChanged bitbucketServerRefsChangedEvent
+	if err := json.Unmarshal(payload, &refsChanged); err != nil {
+		return nil, err
+	}
+	return refsChanged.pushEvents(""), nil
+}
+
+// repositoryURLs returns the clone links of the repository. Older bitbucket server versions don't send them,
+// in that case the http and ssh clone urls are built from the base url, if it is known.
+func (refsChanged bitbucketServerRefsChangedEvent) repositoryURLs(baseURL string) []string {
+	repositoryURLs := make([]string, 0, len(refsChanged.Repository.Links.Clone))
+	for _, link := range refsChanged.Repository.Links.Clone {
+		repositoryURLs = append(repositoryURLs, link.Href)
+	}
+	if len(repositoryURLs) > 0 || baseURL == "" {
+		return repositoryURLs
+	}
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil || base.Host == "" {
+		return repositoryURLs
+	}
+	//like in the clone links bitbucket server sends the project key is lowercased
+	repository := strings.ToLower(refsChanged.Repository.Project.Key) + "/" + refsChanged.Repository.Slug + ".git"
+	return []string{
+		base.String() + "/scm/" + repository,
+		"ssh://git@" + base.Hostname() + "/" + repository,
+	}
+}
+
+func (refsChanged bitbucketServerRefsChangedEvent) repositoryName() string {
+	return refsChanged.Repository.Project.Key + "/" + refsChanged.Repository.Slug
+}
+
+func (refsChanged bitbucketServerRefsChangedEvent) pushEvents(baseURL string) []PushEvent {
+	repositoryURLs := refsChanged.repositoryURLs(baseURL)
+	if len(repositoryURLs) == 0 {
+		log.Printf("Warning: bitbucket server webhook for %s has no clone links, set -bitbucket-server-url to match its resources", refsChanged.repositoryName())
+	}
+	var pushes []PushEvent
+	for _, change := range refsChanged.Changes {
+		ref := change.Ref.ID
+		if ref == "" {
+			ref = change.RefID
+		}
+		if change.Type == "DELETE" {
+			log.Printf("Skipping deletion event for ref %s in %s", ref, refsChanged.repositoryName())
+			continue
+		}
+		log.Printf("Received bitbucket server webhook for %s, ref %s (%s)", refsChanged.repositoryName(), ref, change.Type)
+		pushes = append(pushes, PushEvent{
+			Provider:       "bitbucket-server",
+			RepositoryURLs: repositoryURLs,
+			Ref:            ref,
+			After:          change.ToHash,
+			FilesUnknown:   true,
+		})
+	}
+	return pushes
+}
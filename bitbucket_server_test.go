@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/concourse/concourse/atc"
+)
+
+func TestBitbucketServerWebhookHandler(t *testing.T) {
+	withResourceCache(t, Pipeline{
+		ID:   1,
+		Name: "pipeline",
+		Team: "main",
+		Resources: []atc.ResourceConfig{
+			{Name: "master", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://bitbucket.foo/scm/prj/repo.git", "branch": "master"}},
+			{Name: "develop", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "ssh://git@bitbucket.foo:7999/prj/repo.git", "branch": "develop", "paths": []interface{}{"src/"}}},
+		},
+	})
+	body := `{"eventKey":"repo:refs_changed","repository":{"slug":"repo","project":{"key":"PRJ"},"links":{"clone":[
+		{"href":"ssh://git@bitbucket.foo:7999/prj/repo.git","name":"ssh"},{"href":"https://bitbucket.foo/scm/prj/repo.git","name":"http"}]}},
+		"changes":[
+		{"ref":{"id":"refs/heads/master","displayId":"master","type":"BRANCH"},"refId":"refs/heads/master","type":"UPDATE"},
+		{"ref":{"id":"refs/heads/develop","displayId":"develop","type":"BRANCH"},"refId":"refs/heads/develop","type":"UPDATE"},
+		{"ref":{"id":"refs/heads/gone","displayId":"gone","type":"BRANCH"},"refId":"refs/heads/gone","type":"DELETE"}]}`
+
+	cases := []struct {
+		event       string
+		pathsPolicy string
+		Status      int
+		Queued      int
+	}{
+		{"repo:refs_changed", PathsPolicyTrigger, 200, 2},
+		{"repo:refs_changed", PathsPolicySkip, 200, 1},
+		{"pr:opened", PathsPolicyTrigger, 202, 0},
+		{"diagnostics:ping", PathsPolicyTrigger, 200, 0},
+	}
+	for nr, c := range cases {
+		handler := &BitbucketServerWebhookHandler{NewRequestWorkqueue(1), nil, c.pathsPolicy, ""}
+		req := httptest.NewRequest("POST", "/bitbucket-server", strings.NewReader(body))
+		req.Header.Set("X-Event-Key", c.event)
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Code != c.Status || handler.queue.queue.Len() != c.Queued {
+			t.Errorf("Test case %d failed. Got %d, %d queued", nr+1, rw.Code, handler.queue.queue.Len())
+		}
+	}
+}
+
+func TestBitbucketServerWithoutCloneLinks(t *testing.T) {
+	withResourceCache(t, Pipeline{
+		ID:   1,
+		Name: "pipeline",
+		Team: "main",
+		Resources: []atc.ResourceConfig{
+			{Name: "http", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://bitbucket.foo/bitbucket/scm/proj/repository.git", "branch": "master"}},
+			{Name: "ssh", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "ssh://git@bitbucket.foo:7999/proj/repository.git", "branch": "master"}},
+			{Name: "other", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://bitbucket.foo/bitbucket/scm/proj/other.git", "branch": "master"}},
+		},
+	})
+	//sample payload of the bitbucket server documentation, which has no clone links
+	body := `{"eventKey":"repo:refs_changed","date":"2017-09-19T09:58:11+1000","actor":{"name":"admin","emailAddress":"admin@example.com","id":1,"displayName":"Administrator","active":true,"slug":"admin","type":"NORMAL"},
+		"repository":{"slug":"repository","id":84,"name":"repository","scmId":"git","state":"AVAILABLE","statusMessage":"Available","forkable":true,"project":{"key":"PROJ","id":84,"name":"project","public":false,"type":"NORMAL"},"public":false},
+		"changes":[{"ref":{"id":"refs/heads/master","displayId":"master","type":"BRANCH"},"refId":"refs/heads/master","fromHash":"ecddabb624f6f5ba43816f5926e580a5f680a932","toHash":"178864a7d521b6f5e720b386b2c2b0ef8563e0dc","type":"UPDATE"}]}`
+
+	cases := []struct {
+		baseURL string
+		Queued  int
+	}{
+		{"", 0},
+		{"https://bitbucket.foo/bitbucket/", 2},
+		{"https://bitbucket.foo/bitbucket", 2},
+	}
+	for nr, c := range cases {
+		handler := &BitbucketServerWebhookHandler{NewRequestWorkqueue(1), nil, PathsPolicyTrigger, c.baseURL}
+		req := httptest.NewRequest("POST", "/bitbucket-server", strings.NewReader(body))
+		req.Header.Set("X-Event-Key", "repo:refs_changed")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Code != 200 || handler.queue.queue.Len() != c.Queued {
+			t.Errorf("Test case %d failed. Got %d, %d queued", nr+1, rw.Code, handler.queue.queue.Len())
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Kinds of changes a cloudevent type can be mapped to
+const (
+	CloudEventKindPush        = "push"
+	CloudEventKindTag         = "tag"
+	CloudEventKindPullRequest = "pull-request"
+)
+
+// pushParsers are the provider parsers available to the cloudevents ingress
+var pushParsers = map[string]func(payload []byte) ([]PushEvent, error){
+	"github":           parseGithubPush,
+	"gitlab":           parseGitlabPush,
+	"gitea":            parseGiteaPush,
+	"bitbucket-server": parseBitbucketServerPush,
+	"bitbucket-cloud":  parseBitbucketCloudPush,
+	"azure-devops":     parseAzureDevOpsPush,
+}
+
+// pullRequestParsers are the provider pull request parsers available to the cloudevents ingress
+var pullRequestParsers = map[string]func(payload []byte) (*PullRequestEvent, error){
+	"github": func(payload []byte) (*PullRequestEvent, error) {
+		pr, _, err := parseGithubPullRequest("pull_request", payload)
+		return pr, err
+	},
+}
+
+// CloudEventsConfig configures the cloudevents ingress
+type CloudEventsConfig struct {
+	//SecretHeader is the header carrying the shared secret (default X-Webhook-Token)
+	SecretHeader string           `json:"secret_header"`
+	Secrets      []string         `json:"secrets"`
+	Types        []CloudEventType `json:"types"`
+}
+
+// CloudEventType maps a cloudevent type to the provider parser handling its data
+type CloudEventType struct {
+	Type string `json:"type"`
+	//Provider is one of the keys of pushParsers or generic/<name> for a generic mapping
+	Provider string `json:"provider"`
+	//Kind restricts the events to branch pushes (push) or tag pushes (tag), empty allows both.
+	//pull-request handles the data as pull request event.
+	Kind string `json:"kind"`
+	//PathsPolicy applies to resources with a path filter if the provider doesn't send changed files
+	PathsPolicy string `json:"paths_policy"`
+
+	parser            func(payload []byte) ([]PushEvent, error)
+	pullRequestParser func(payload []byte) (*PullRequestEvent, error)
+}
+
+func (c *CloudEventsConfig) compile(generic []GenericMapping) error {
+	if c.SecretHeader == "" {
+		c.SecretHeader = "X-Webhook-Token"
+	}
+	types := map[string]bool{}
+	for i := range c.Types {
+		t := &c.Types[i]
+		if t.Type == "" {
+			return fmt.Errorf("Type must be given")
+		}
+		if types[t.Type] {
+			return fmt.Errorf("Duplicate type %s", t.Type)
+		}
+		types[t.Type] = true
+		if t.Kind != "" && t.Kind != CloudEventKindPush && t.Kind != CloudEventKindTag && t.Kind != CloudEventKindPullRequest {
+			return fmt.Errorf("Invalid kind %s for type %s, must be one of: %s, %s, %s", t.Kind, t.Type, CloudEventKindPush, CloudEventKindTag, CloudEventKindPullRequest)
+		}
+		if t.PathsPolicy == "" {
+			t.PathsPolicy = PathsPolicyTrigger
+		}
+		if !validPathsPolicy(t.PathsPolicy) {
+			return fmt.Errorf("Invalid paths_policy %s for type %s", t.PathsPolicy, t.Type)
+		}
+		if t.Kind == CloudEventKindPullRequest {
+			if t.pullRequestParser = pullRequestParsers[t.Provider]; t.pullRequestParser == nil {
+				return fmt.Errorf("Provider %s doesn't support pull requests for type %s", t.Provider, t.Type)
+			}
+			continue
+		}
+		if name := strings.TrimPrefix(t.Provider, "generic/"); name != t.Provider {
+			for j := range generic {
+				if generic[j].Name == name {
+					mapping := &generic[j]
+					t.parser = func(payload []byte) ([]PushEvent, error) {
+						push, ok, err := mapping.Extract(payload)
+						if err != nil || !ok {
+							return nil, err
+						}
+						return []PushEvent{push}, nil
+					}
+				}
+			}
+		} else {
+			t.parser = pushParsers[t.Provider]
+		}
+		if t.parser == nil {
+			return fmt.Errorf("Unknown provider %s for type %s", t.Provider, t.Type)
+		}
+	}
+	return nil
+}
+
+// CloudEventsHandler accepts cloudevents in binary and structured http mode
+type CloudEventsHandler struct {
+	queue  *RequestWorkqueue
+	config *CloudEventsConfig
+}
+
+type cloudEvent struct {
+	SpecVersion string          `json:"specversion"`
+	Type        string          `json:"type"`
+	Source      string          `json:"source"`
+	ID          string          `json:"id"`
+	Data        json.RawMessage `json:"data"`
+	DataBase64  string          `json:"data_base64"`
+}
+
+// readCloudEvent decodes a cloudevent in binary (ce-* headers) or structured (application/cloudevents+json) mode
+func readCloudEvent(req *http.Request, body []byte) (cloudEvent, error) {
+	var event cloudEvent
+	contentType := req.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "application/cloudevents-batch") {
+		return event, fmt.Errorf("Batched cloudevents are not supported")
+	}
+	if strings.HasPrefix(contentType, "application/cloudevents") {
+		if err := json.Unmarshal(body, &event); err != nil {
+			return event, err
+		}
+		if event.DataBase64 != "" {
+			data, err := base64.StdEncoding.DecodeString(event.DataBase64)
+			if err != nil {
+				return event, fmt.Errorf("Invalid data_base64: %s", err)
+			}
+			event.Data = data
+		}
+	} else {
+		event = cloudEvent{
+			SpecVersion: req.Header.Get("ce-specversion"),
+			Type:        req.Header.Get("ce-type"),
+			Source:      req.Header.Get("ce-source"),
+			ID:          req.Header.Get("ce-id"),
+			Data:        body,
+		}
+	}
+	if event.SpecVersion == "" || event.Type == "" {
+		return event, fmt.Errorf("Missing specversion or type")
+	}
+	return event, nil
+}
+
+func (ce *CloudEventsHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if len(ce.config.Secrets) > 0 {
+		token := req.Header.Get(ce.config.SecretHeader)
+		if token == "" {
+			signatureRejections.WithLabelValues("cloudevents", "missing").Inc()
+			http.Error(rw, "Missing "+ce.config.SecretHeader+" header", http.StatusUnauthorized)
+			log.Printf("Rejecting cloudevent without secret")
+			return
+		}
+		if !verifyToken(token, ce.config.Secrets) {
+			signatureRejections.WithLabelValues("cloudevents", "invalid").Inc()
+			http.Error(rw, "Invalid secret", http.StatusUnauthorized)
+			log.Printf("Rejecting cloudevent with invalid secret")
+			return
+		}
+	}
+
+	body, err := readBody(rw, req)
+	if err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to read request body: %s", err)
+		return
+	}
+	event, err := readCloudEvent(req, body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		log.Printf("Failed to parse cloudevent: %s", err)
+		return
+	}
+
+	var eventType *CloudEventType
+	for i := range ce.config.Types {
+		if ce.config.Types[i].Type == event.Type {
+			eventType = &ce.config.Types[i]
+		}
+	}
+	if eventType == nil {
+		log.Printf("Ignoring unmapped cloudevent type %s from %s", event.Type, event.Source)
+		rw.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(rw, "ignored: type %s is not mapped\n", event.Type)
+		return
+	}
+
+	if eventType.pullRequestParser != nil {
+		ce.handlePullRequest(rw, event, eventType)
+		return
+	}
+
+	pushes, err := eventType.parser(event.Data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		log.Printf("Failed to parse data of cloudevent %s (%s) with provider %s: %s", event.ID, event.Type, eventType.Provider, err)
+		return
+	}
+	log.Printf("Received cloudevent %s (%s) from %s", event.ID, event.Type, event.Source)
+	for _, push := range pushes {
+		if eventType.Kind == CloudEventKindPush && !strings.HasPrefix(push.Ref, "refs/heads/") ||
+			eventType.Kind == CloudEventKindTag && !strings.HasPrefix(push.Ref, "refs/tags/") {
+			debugf("Skipping ref %s of cloudevent %s, type is mapped to %s", push.Ref, event.ID, eventType.Kind)
+			continue
+		}
+		if push.PathsPolicy == "" {
+			push.PathsPolicy = eventType.PathsPolicy
+		}
+		BroadcastPush(ce.queue, push)
+	}
+}
+
+func (ce *CloudEventsHandler) handlePullRequest(rw http.ResponseWriter, event cloudEvent, eventType *CloudEventType) {
+	pr, err := eventType.pullRequestParser(event.Data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		log.Printf("Failed to parse data of cloudevent %s (%s) with provider %s: %s", event.ID, event.Type, eventType.Provider, err)
+		return
+	}
+	if pr == nil {
+		rw.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(rw, "ignored: action is not handled\n")
+		return
+	}
+	log.Printf("Received cloudevent %s (%s) from %s", event.ID, event.Type, event.Source)
+	pr.PathsPolicy = eventType.PathsPolicy
+	BroadcastPullRequest(ce.queue, *pr)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/concourse/concourse/atc"
+)
+
+func TestCloudEventsHandler(t *testing.T) {
+	withResourceCache(t, Pipeline{
+		ID:   1,
+		Name: "pipeline",
+		Team: "main",
+		Resources: []atc.ResourceConfig{
+			{Name: "main", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://gitlab.foo/group/repo.git", "branch": "main"}},
+		},
+	})
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yaml")
+	os.WriteFile(configFile, []byte(`
+cloudevents:
+  secrets: [ s3cr3t ]
+  types:
+  - type: com.gitlab.push
+    provider: gitlab
+    kind: push
+  - type: com.gitlab.tag
+    provider: gitlab
+    kind: tag
+`), 0644)
+	config, err := LoadConfig(configFile)
+	if err != nil {
+		t.Fatalf("Failed to load config: %s", err)
+	}
+
+	data := `{"object_kind":"push","ref":"refs/heads/main","after":"abc","project":{"git_http_url":"https://gitlab.foo/group/repo.git","default_branch":"main"}}`
+	structured := `{"specversion":"1.0","type":"com.gitlab.push","source":"/gitlab","id":"1","data":` + data + `}`
+	structuredBase64 := `{"specversion":"1.0","type":"com.gitlab.push","source":"/gitlab","id":"1","data_base64":"eyJvYmplY3Rfa2luZCI6InB1c2giLCJyZWYiOiJyZWZzL2hlYWRzL21haW4iLCJhZnRlciI6ImFiYyIsInByb2plY3QiOnsiZ2l0X2h0dHBfdXJsIjoiaHR0cHM6Ly9naXRsYWIuZm9vL2dyb3VwL3JlcG8uZ2l0IiwiZGVmYXVsdF9icmFuY2giOiJtYWluIn19"}`
+
+	cases := []struct {
+		headers map[string]string
+		body    string
+		Status  int
+		Queued  int
+	}{
+		{map[string]string{"ce-specversion": "1.0", "ce-type": "com.gitlab.push", "ce-id": "1", "Content-Type": "application/json"}, data, 200, 1},
+		{map[string]string{"Content-Type": "application/cloudevents+json"}, structured, 200, 1},
+		{map[string]string{"Content-Type": "application/cloudevents+json"}, structuredBase64, 200, 1},
+		{map[string]string{"ce-specversion": "1.0", "ce-type": "com.gitlab.tag", "ce-id": "1"}, data, 200, 0},
+		{map[string]string{"ce-specversion": "1.0", "ce-type": "com.other", "ce-id": "1"}, data, 202, 0},
+		{map[string]string{"Content-Type": "application/json"}, data, 400, 0},
+		{map[string]string{"Content-Type": "application/cloudevents-batch+json"}, "[]", 400, 0},
+	}
+	for nr, c := range cases {
+		handler := &CloudEventsHandler{NewRequestWorkqueue(1), config.CloudEvents}
+		req := httptest.NewRequest("POST", "/cloudevents", strings.NewReader(c.body))
+		req.Header.Set("X-Webhook-Token", "s3cr3t")
+		for k, v := range c.headers {
+			req.Header.Set(k, v)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Code != c.Status || handler.queue.queue.Len() != c.Queued {
+			t.Errorf("Test case %d failed. Got %d, %d queued", nr+1, rw.Code, handler.queue.queue.Len())
+		}
+	}
+}
+
+func TestCloudEventsConfig(t *testing.T) {
+	cases := []struct {
+		config CloudEventsConfig
+		Error  bool
+	}{
+		{CloudEventsConfig{Types: []CloudEventType{{Type: "a", Provider: "github"}}}, false},
+		{CloudEventsConfig{Types: []CloudEventType{{Type: "a", Provider: "generic/tool"}}}, false},
+		{CloudEventsConfig{Types: []CloudEventType{{Type: "a", Provider: "generic/unknown"}}}, true},
+		{CloudEventsConfig{Types: []CloudEventType{{Type: "a", Provider: "svn"}}}, true},
+		{CloudEventsConfig{Types: []CloudEventType{{Type: "a", Provider: "github", Kind: "merge"}}}, true},
+		{CloudEventsConfig{Types: []CloudEventType{{Type: "a", Provider: "github"}, {Type: "a", Provider: "gitlab"}}}, true},
+	}
+	for nr, c := range cases {
+		if err := c.config.compile([]GenericMapping{{Name: "tool"}}); (err != nil) != c.Error {
+			t.Errorf("Test case %d failed. Got %v", nr+1, err)
+		}
+	}
+}
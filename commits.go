@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/concourse/concourse/atc"
+)
+
+// PushCommit is a commit of a push event
+type PushCommit struct {
+	ID      string
+	Message string
+	//Files are the files changed by the commit
+	Files []string
+}
+
+// ciSkipPattern matches the messages of commits the git resource skips unless disable_ci_skip is set
+var ciSkipPattern = regexp.MustCompile(`\[(ci\sskip|skip\sci)\]`)
+
+// commitFilter is the precompiled commit message filter of a git resource,
+// the [ci skip] handling and the commit_filter include and exclude patterns.
+type commitFilter struct {
+	ciSkip     bool
+	exclude    []*regexp.Regexp
+	excludeAll bool
+	include    []*regexp.Regexp
+	includeAll bool
+}
+
+// newCommitFilter returns the commit message filter of a git resource or nil if it doesn't filter commits
+func newCommitFilter(source atc.Source) *commitFilter {
+	disableCISkip, _ := source["disable_ci_skip"].(bool)
+	filter := &commitFilter{ciSkip: !disableCISkip}
+	if commitFilterSource, ok := source["commit_filter"].(map[string]interface{}); ok {
+		filter.exclude = compileMessagePatterns(sourceStrings(commitFilterSource, "exclude"))
+		filter.excludeAll, _ = commitFilterSource["exclude_all_match"].(bool)
+		filter.include = compileMessagePatterns(sourceStrings(commitFilterSource, "include"))
+		filter.includeAll, _ = commitFilterSource["include_all_match"].(bool)
+	}
+	if !filter.ciSkip && len(filter.exclude) == 0 && len(filter.include) == 0 {
+		return nil
+	}
+	return filter
+}
+
+// compileMessagePatterns compiles the patterns the git resource passes to git log --grep.
+// Patterns that aren't valid regular expressions are matched literally.
+func compileMessagePatterns(patterns []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			re = regexp.MustCompile(regexp.QuoteMeta(pattern))
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled
+}
+
+// Skip returns the reason why the git resource skips a commit with the message or an empty string
+func (f *commitFilter) Skip(message string) string {
+	if f == nil {
+		return ""
+	}
+	if f.ciSkip && ciSkipPattern.MatchString(message) {
+		return "ci skip"
+	}
+	if len(f.exclude) > 0 && matchMessage(f.exclude, message, f.excludeAll) {
+		return "commit_filter exclude"
+	}
+	if len(f.include) > 0 && !matchMessage(f.include, message, f.includeAll) {
+		return "commit_filter include"
+	}
+	return ""
+}
+
+func matchMessage(patterns []*regexp.Regexp, message string, all bool) bool {
+	for _, pattern := range patterns {
+		matched := pattern.MatchString(message)
+		if matched && !all {
+			return true
+		}
+		if !matched && all {
+			return false
+		}
+	}
+	return all
+}
+
+// skipCommits returns the reason why none of the pushed commits yields a new version of the resource or an empty string.
+// A commit yields a new version if it passes the commit message filter and changes a file matching the path filter.
+func skipCommits(entry *indexedResource, push PushEvent) string {
+	if entry.commits == nil && entry.paths == nil {
+		return ""
+	}
+	if len(push.Commits) == 0 {
+		//only the aggregated changed files are known
+		if entry.paths != nil && !push.FilesUnknown && !entry.paths.Match(push.FilesChanged) {
+			return "due to path filter"
+		}
+		return ""
+	}
+	skipped := map[string]int{}
+	var reasons []string
+	for _, commit := range push.Commits {
+		if reason := skipCommit(entry, push, commit); reason != "" {
+			debugf("commit %s of %s/%s skipped: %s", commit.ID, entry.pipeline.Ref(), entry.resource.Name, reason)
+			if skipped[reason] == 0 {
+				reasons = append(reasons, reason)
+			}
+			skipped[reason]++
+			continue
+		}
+		return ""
+	}
+	summary := make([]string, 0, len(reasons))
+	for _, reason := range reasons {
+		summary = append(summary, fmt.Sprintf("%s: %d", reason, skipped[reason]))
+	}
+	return fmt.Sprintf("all %d commits are skipped (%s)", len(push.Commits), strings.Join(summary, ", "))
+}
+
+// skipCommit returns the reason why a pushed commit doesn't yield a new version of the resource or an empty string
+func skipCommit(entry *indexedResource, push PushEvent, commit PushCommit) string {
+	if reason := entry.commits.Skip(commit.Message); reason != "" {
+		return reason
+	}
+	if entry.paths != nil && !push.FilesUnknown && !entry.paths.Match(commit.Files) {
+		return "path filter"
+	}
+	return ""
+}
+
+// checkFromRef returns the pushed commit a check of the resource can start from, or an empty string for a regular check.
+// Only the head of a branch is used and only if it passes the filters of the resource, otherwise a check from it
+// wouldn't find the commits that did pass. Tags are versioned differently by the git resource.
+func checkFromRef(entry *indexedResource, push PushEvent) string {
+	if push.After == "" || !strings.HasPrefix(push.Ref, "refs/heads/") || tracksTags(entry.resource) {
+		return ""
+	}
+	if entry.paths == nil && entry.commits == nil {
+		return push.After
+	}
+	if push.Incomplete != "" || entry.paths != nil && push.FilesUnknown {
+		return ""
+	}
+	for _, commit := range push.Commits {
+		if commit.ID == push.After && skipCommit(entry, push, commit) == "" {
+			return push.After
+		}
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/concourse/concourse/atc"
+)
+
+func TestCommitFilter(t *testing.T) {
+	cases := []struct {
+		source  atc.Source
+		message string
+		Skipped bool
+	}{
+		{atc.Source{}, "Fix bug", false},
+		{atc.Source{}, "Fix bug [ci skip]", true},
+		{atc.Source{}, "[skip ci] Update docs", true},
+		{atc.Source{}, "Update docs\n\n[skip\tci]", true},
+		{atc.Source{}, "[CI SKIP] Update docs", false},
+		{atc.Source{}, "ci skip", false},
+		{atc.Source{"disable_ci_skip": true}, "Fix bug [ci skip]", false},
+		{atc.Source{"disable_ci_skip": false}, "Fix bug [ci skip]", true},
+		//exclude: any pattern found in the message excludes the commit
+		{atc.Source{"commit_filter": map[string]interface{}{"exclude": []interface{}{"WIP", "chore"}}}, "WIP: new feature", true},
+		{atc.Source{"commit_filter": map[string]interface{}{"exclude": []interface{}{"WIP", "chore"}}}, "chore: bump deps", true},
+		{atc.Source{"commit_filter": map[string]interface{}{"exclude": []interface{}{"WIP", "chore"}}}, "feat: new feature", false},
+		{atc.Source{"commit_filter": map[string]interface{}{"exclude": []interface{}{"^Merge"}}}, "Merge branch 'main'", true},
+		{atc.Source{"commit_filter": map[string]interface{}{"exclude": []interface{}{"^Merge"}}}, "Revert Merge branch 'main'", false},
+		{atc.Source{"commit_filter": map[string]interface{}{"exclude": []interface{}{"[WIP"}}}, "[WIP new feature", true},
+		//exclude_all_match: all patterns have to be found
+		{atc.Source{"commit_filter": map[string]interface{}{"exclude": []interface{}{"WIP", "chore"}, "exclude_all_match": true}}, "WIP: new feature", false},
+		{atc.Source{"commit_filter": map[string]interface{}{"exclude": []interface{}{"WIP", "chore"}, "exclude_all_match": true}}, "WIP chore: bump deps", true},
+		//include: one of the patterns has to be found
+		{atc.Source{"commit_filter": map[string]interface{}{"include": []interface{}{"release", "hotfix"}}}, "hotfix: crash", false},
+		{atc.Source{"commit_filter": map[string]interface{}{"include": []interface{}{"release", "hotfix"}}}, "feat: new feature", true},
+		//include_all_match: all patterns have to be found
+		{atc.Source{"commit_filter": map[string]interface{}{"include": []interface{}{"release", "v[0-9]+"}, "include_all_match": true}}, "release v2", false},
+		{atc.Source{"commit_filter": map[string]interface{}{"include": []interface{}{"release", "v[0-9]+"}, "include_all_match": true}}, "release next", true},
+		//include and exclude
+		{atc.Source{"commit_filter": map[string]interface{}{"include": []interface{}{"deploy"}, "exclude": []interface{}{"staging"}}}, "deploy production", false},
+		{atc.Source{"commit_filter": map[string]interface{}{"include": []interface{}{"deploy"}, "exclude": []interface{}{"staging"}}}, "deploy staging", true},
+		{atc.Source{"commit_filter": map[string]interface{}{"include": []interface{}{"deploy"}}}, "deploy [ci skip]", true},
+	}
+	for nr, c := range cases {
+		filter := newCommitFilter(c.source)
+		if (filter.Skip(c.message) != "") != c.Skipped {
+			t.Errorf("Test case %d failed.", nr+1)
+		}
+	}
+	if filter := newCommitFilter(atc.Source{"disable_ci_skip": true}); filter != nil {
+		t.Errorf("Expected no filter, got %v", filter)
+	}
+}
+
+func TestBroadcastPushCommits(t *testing.T) {
+	withResourceCache(t, Pipeline{
+		ID:   1,
+		Name: "pipeline",
+		Team: "main",
+		Resources: []atc.ResourceConfig{
+			{Name: "default", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/some/repo.git"}},
+			{Name: "no-ci-skip", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/some/repo.git", "disable_ci_skip": true}},
+			{Name: "charts", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/some/repo.git", "paths": []interface{}{"charts/"}}},
+			{Name: "releases", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/some/repo.git", "commit_filter": map[string]interface{}{"include": []interface{}{"^release"}}}},
+		},
+	})
+
+	cases := []struct {
+		commits []PushCommit
+		Result  int
+	}{
+		{[]PushCommit{{ID: "1", Message: "Fix bug", Files: []string{"main.go"}}}, 2},
+		{[]PushCommit{{ID: "1", Message: "Update docs [ci skip]", Files: []string{"charts/README.md"}}}, 1},
+		{[]PushCommit{{ID: "1", Message: "Update docs [ci skip]", Files: []string{"charts/README.md"}}, {ID: "2", Message: "Fix bug", Files: []string{"main.go"}}}, 2},
+		//the commit changing charts/ is skipped, the other one doesn't change charts/
+		{[]PushCommit{{ID: "1", Message: "Bump chart [skip ci]", Files: []string{"charts/values.yaml"}}, {ID: "2", Message: "Fix bug", Files: []string{"main.go"}}}, 2},
+		{[]PushCommit{{ID: "1", Message: "Bump chart", Files: []string{"charts/values.yaml"}}, {ID: "2", Message: "release 1.0", Files: []string{"VERSION"}}}, 4},
+	}
+	queue := NewRequestWorkqueue(1)
+	for nr, c := range cases {
+		push := PushEvent{RepositoryURLs: []string{"https://git.foo/some/repo.git"}, Ref: "refs/heads/master", DefaultBranch: "master", Commits: c.commits}
+		for _, commit := range c.commits {
+			push.FilesChanged = append(push.FilesChanged, commit.Files...)
+		}
+		if result := BroadcastPush(queue, push); result != c.Result {
+			t.Errorf("Test case %d failed. Got %d", nr+1, result)
+		}
+	}
+}
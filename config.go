@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the optional configuration file (yaml or json) given with -config-file
+type Config struct {
+	Generic     []GenericMapping   `json:"generic"`
+	CloudEvents *CloudEventsConfig `json:"cloudevents"`
+	//ResourceTypes maps base resource types to the image repositories of custom resource_types wrapping them
+	ResourceTypes map[string][]string `json:"resource_types"`
+	//GitHostAliases maps alternative hosts of git servers to their canonical host
+	GitHostAliases map[string]string `json:"git_host_aliases"`
+}
+
+// LoadConfig reads and validates the configuration file
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{}
+	if path == "" {
+		return config, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %s", path, err)
+	}
+	names := map[string]bool{}
+	for i := range config.Generic {
+		mapping := &config.Generic[i]
+		if err := mapping.compile(); err != nil {
+			return nil, fmt.Errorf("Invalid generic mapping %s: %s", mapping.Name, err)
+		}
+		if names[mapping.Name] {
+			return nil, fmt.Errorf("Duplicate generic mapping %s", mapping.Name)
+		}
+		names[mapping.Name] = true
+	}
+	for baseType := range config.ResourceTypes {
+		if !baseResourceTypes[baseType] {
+			return nil, fmt.Errorf("Unknown base resource type %s in resource_types", baseType)
+		}
+	}
+	if config.CloudEvents != nil {
+		if err := config.CloudEvents.compile(config.Generic); err != nil {
+			return nil, fmt.Errorf("Invalid cloudevents config: %s", err)
+		}
+	}
+	return config, nil
+}
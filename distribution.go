@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// DistributionWebhookHandler handles notifications of the cncf distribution registry
+type DistributionWebhookHandler struct {
+	queue *RequestWorkqueue
+	//secrets are the accepted values of the Authorization header configured in the notification endpoint
+	secrets []string
+}
+
+type distributionEnvelope struct {
+	Events []struct {
+		ID     string `json:"id"`
+		Action string `json:"action"`
+		Target struct {
+			MediaType  string `json:"mediaType"`
+			Digest     string `json:"digest"`
+			Repository string `json:"repository"`
+			URL        string `json:"url"`
+			Tag        string `json:"tag"`
+		} `json:"target"`
+		Request struct {
+			Host string `json:"host"`
+		} `json:"request"`
+	} `json:"events"`
+}
+
+func (d *DistributionWebhookHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if reason := verifyAuthorizationHeader(req, d.secrets); reason != "" {
+		signatureRejections.WithLabelValues("distribution", reason).Inc()
+		http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+		log.Printf("Rejecting registry notification: %s authorization header", reason)
+		return
+	}
+
+	body, err := readBody(rw, req)
+	if err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to read request body: %s", err)
+		return
+	}
+	pushes, err := parseDistributionPush(body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		log.Printf("Failed to parse request body: %s", err)
+		return
+	}
+	notified := 0
+	for _, push := range pushes {
+		notified += BroadcastImagePush(d.queue, push)
+	}
+	fmt.Fprintf(rw, "ok: %d resource(s) notified\n", notified)
+}
+
+// parseDistributionPush returns the tagged manifest pushes of a notification envelope, other events are skipped
+func parseDistributionPush(payload []byte) ([]ImagePushEvent, error) {
+	var envelope distributionEnvelope
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return nil, err
+	}
+	var pushes []ImagePushEvent
+	for _, event := range envelope.Events {
+		//blob pushes and pulls don't change any tag
+		if event.Action != "push" || event.Target.Tag == "" {
+			debugf("Skipping registry event %s (%s) for %s", event.ID, event.Action, event.Target.Repository)
+			continue
+		}
+		host := event.Request.Host
+		if u, err := url.Parse(event.Target.URL); host == "" && err == nil {
+			host = u.Host
+		}
+		pushes = append(pushes, ImagePushEvent{
+			Provider:   "distribution",
+			Host:       host,
+			Repository: event.Target.Repository,
+			Tag:        event.Target.Tag,
+		})
+	}
+	return pushes, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseDistributionPush(t *testing.T) {
+	body := `{"events":[
+	{"id":"1","action":"push","target":{"mediaType":"application/vnd.docker.distribution.manifest.v2+json","digest":"sha256:abc","repository":"foo/bar","url":"http://registry:5000/v2/foo/bar/manifests/sha256:abc","tag":"latest"},"request":{"host":"registry.example.com"}},
+	{"id":"2","action":"push","target":{"mediaType":"application/octet-stream","digest":"sha256:def","repository":"foo/bar","url":"http://registry:5000/v2/foo/bar/blobs/sha256:def"}},
+	{"id":"3","action":"pull","target":{"repository":"foo/bar","tag":"latest"}},
+	{"id":"4","action":"push","target":{"repository":"foo/baz","url":"http://registry:5000/v2/foo/baz/manifests/sha256:abc","tag":"1.0"}}
+]}`
+	pushes, err := parseDistributionPush([]byte(body))
+	if err != nil {
+		t.Fatalf("Failed to parse notification: %s", err)
+	}
+	expected := []ImagePushEvent{
+		{Provider: "distribution", Host: "registry.example.com", Repository: "foo/bar", Tag: "latest"},
+		{Provider: "distribution", Host: "registry:5000", Repository: "foo/baz", Tag: "1.0"},
+	}
+	if len(pushes) != len(expected) {
+		t.Fatalf("Expected %d pushes, got %#v", len(expected), pushes)
+	}
+	for i := range expected {
+		if pushes[i] != expected[i] {
+			t.Errorf("Push %d: expected %#v, got %#v", i+1, expected[i], pushes[i])
+		}
+	}
+}
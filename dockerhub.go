@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// DockerHubWebhookHandler handles push webhooks of docker hub
+type DockerHubWebhookHandler struct {
+	queue *RequestWorkqueue
+	//tokens are the accepted values of the token query parameter, docker hub can't send secrets in headers
+	tokens []string
+}
+
+type dockerHubPushEvent struct {
+	PushData struct {
+		Tag    string `json:"tag"`
+		Pusher string `json:"pusher"`
+	} `json:"push_data"`
+	Repository struct {
+		RepoName string `json:"repo_name"`
+	} `json:"repository"`
+}
+
+func (dh *DockerHubWebhookHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if len(dh.tokens) > 0 {
+		token := req.URL.Query().Get("token")
+		if token == "" {
+			signatureRejections.WithLabelValues("dockerhub", "missing").Inc()
+			http.Error(rw, "Missing token", http.StatusUnauthorized)
+			log.Printf("Rejecting docker hub webhook without token")
+			return
+		}
+		if !verifyToken(token, dh.tokens) {
+			signatureRejections.WithLabelValues("dockerhub", "invalid").Inc()
+			http.Error(rw, "Invalid token", http.StatusUnauthorized)
+			log.Printf("Rejecting docker hub webhook with invalid token")
+			return
+		}
+	}
+
+	body, err := readBody(rw, req)
+	if err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to read request body: %s", err)
+		return
+	}
+	push, err := parseDockerHubPush(body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		log.Printf("Failed to parse request body: %s", err)
+		return
+	}
+	notified := BroadcastImagePush(dh.queue, push)
+	fmt.Fprintf(rw, "ok: %d resource(s) notified\n", notified)
+}
+
+// parseDockerHubPush returns the image push of a docker hub webhook payload
+func parseDockerHubPush(payload []byte) (ImagePushEvent, error) {
+	var pushEvent dockerHubPushEvent
+	if err := json.Unmarshal(payload, &pushEvent); err != nil {
+		return ImagePushEvent{}, err
+	}
+	if pushEvent.Repository.RepoName == "" {
+		return ImagePushEvent{}, fmt.Errorf("Missing repository.repo_name")
+	}
+	return ImagePushEvent{
+		Provider:   "dockerhub",
+		Host:       dockerHubHost,
+		Repository: pushEvent.Repository.RepoName,
+		Tag:        pushEvent.PushData.Tag,
+	}, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/concourse/concourse/atc"
+)
+
+func TestDockerHubWebhookHandler(t *testing.T) {
+	withResourceCache(t, Pipeline{
+		ID:   1,
+		Name: "pipeline",
+		Team: "main",
+		Resources: []atc.ResourceConfig{
+			{Name: "app", Type: "registry-image", WebhookToken: "t", Source: atc.Source{"repository": "someorg/app"}},
+		},
+	})
+	body := `{"callback_url":"https://registry.hub.docker.com/u/someorg/app/hook/abc/","push_data":{"tag":"latest","pusher":"someone"},"repository":{"repo_name":"someorg/app","namespace":"someorg","name":"app"}}`
+
+	cases := []struct {
+		target string
+		body   string
+		Status int
+		Queued int
+	}{
+		{"/dockerhub?token=s3cr3t", body, 200, 1},
+		{"/dockerhub?token=wrong", body, 401, 0},
+		{"/dockerhub", body, 401, 0},
+		{"/dockerhub?token=s3cr3t", `{}`, 400, 0},
+		{"/dockerhub?token=s3cr3t", `not json`, 400, 0},
+	}
+	for nr, c := range cases {
+		handler := &DockerHubWebhookHandler{NewRequestWorkqueue(1), []string{"s3cr3t"}}
+		req := httptest.NewRequest("POST", c.target, strings.NewReader(c.body))
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Code != c.Status || handler.queue.queue.Len() != c.Queued {
+			t.Errorf("Test case %d failed. Got %d, %d queued", nr+1, rw.Code, handler.queue.queue.Len())
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import "strings"
+
+// stringSliceFlag is a flag.Value that can be given multiple times
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Kinds an extracted generic event can be mapped to
+const (
+	GenericKindBranch = "branch"
+	GenericKindTag    = "tag"
+)
+
+// GenericMapping describes how to extract a push event from an arbitrary json payload
+type GenericMapping struct {
+	//Name of the mapping, the endpoint is /generic/<name>
+	Name string `json:"name"`
+	//SecretHeader is the header carrying the shared secret (default X-Webhook-Token)
+	SecretHeader string   `json:"secret_header"`
+	Secrets      []string `json:"secrets"`
+	//Repository, Ref, Files and Kind are json path expressions, e.g. $.repo.url or changes[*].path
+	Repository string `json:"repository"`
+	Ref        string `json:"ref"`
+	Files      string `json:"files"`
+	Kind       string `json:"kind"`
+	//Kinds maps extracted kind values to branch or tag. Kinds not listed are ignored.
+	Kinds map[string]string `json:"kinds"`
+	//PathsPolicy applies to resources with a path filter if no files expression is configured
+	PathsPolicy string `json:"paths_policy"`
+
+	repository []jsonPathStep
+	ref        []jsonPathStep
+	files      []jsonPathStep
+	kind       []jsonPathStep
+}
+
+func (m *GenericMapping) compile() error {
+	var err error
+	if m.Name == "" || strings.Contains(m.Name, "/") {
+		return fmt.Errorf("Name must be given and must not contain /")
+	}
+	if m.SecretHeader == "" {
+		m.SecretHeader = "X-Webhook-Token"
+	}
+	if m.PathsPolicy == "" {
+		m.PathsPolicy = PathsPolicyTrigger
+	}
+	if !validPathsPolicy(m.PathsPolicy) {
+		return fmt.Errorf("Invalid paths_policy %s", m.PathsPolicy)
+	}
+	for value, kind := range m.Kinds {
+		if kind != GenericKindBranch && kind != GenericKindTag {
+			return fmt.Errorf("Invalid kind %s for %s, must be one of: %s, %s", kind, value, GenericKindBranch, GenericKindTag)
+		}
+	}
+	if m.repository, err = parseJSONPath(m.Repository); err != nil {
+		return fmt.Errorf("repository: %s", err)
+	}
+	if m.ref, err = parseJSONPath(m.Ref); err != nil {
+		return fmt.Errorf("ref: %s", err)
+	}
+	if m.Files != "" {
+		if m.files, err = parseJSONPath(m.Files); err != nil {
+			return fmt.Errorf("files: %s", err)
+		}
+	}
+	if m.Kind != "" {
+		if m.kind, err = parseJSONPath(m.Kind); err != nil {
+			return fmt.Errorf("kind: %s", err)
+		}
+	}
+	return nil
+}
+
+// Extract returns the push event described by the payload.
+// It returns false if the kind of the event is not mapped.
+func (m *GenericMapping) Extract(payload []byte) (PushEvent, bool, error) {
+	var doc interface{}
+	if err := json.Unmarshal(payload, &doc); err != nil {
+		return PushEvent{}, false, err
+	}
+	push := PushEvent{
+		Provider:       "generic/" + m.Name,
+		RepositoryURLs: jsonPathStrings(m.repository, doc),
+		PathsPolicy:    m.PathsPolicy,
+	}
+	if len(push.RepositoryURLs) == 0 {
+		return push, false, fmt.Errorf("No repository found at %s", m.Repository)
+	}
+	refs := jsonPathStrings(m.ref, doc)
+	if len(refs) == 0 {
+		return push, false, fmt.Errorf("No ref found at %s", m.Ref)
+	}
+
+	kind := GenericKindBranch
+	if m.kind != nil {
+		kinds := jsonPathStrings(m.kind, doc)
+		if len(kinds) == 0 {
+			return push, false, fmt.Errorf("No kind found at %s", m.Kind)
+		}
+		var ok bool
+		if kind, ok = m.Kinds[kinds[0]]; !ok {
+			return push, false, nil
+		}
+	}
+	push.Ref = refs[0]
+	if !strings.HasPrefix(push.Ref, "refs/") {
+		if kind == GenericKindTag {
+			push.Ref = "refs/tags/" + push.Ref
+		} else {
+			push.Ref = "refs/heads/" + push.Ref
+		}
+	}
+
+	if m.files != nil {
+		push.FilesChanged = jsonPathStrings(m.files, doc)
+	} else {
+		push.FilesUnknown = true
+	}
+	return push, true, nil
+}
+
+// GenericWebhookHandler handles json payloads of arbitrary tools using configured mappings
+type GenericWebhookHandler struct {
+	queue    *RequestWorkqueue
+	mappings map[string]*GenericMapping
+}
+
+func NewGenericWebhookHandler(queue *RequestWorkqueue, mappings []GenericMapping) *GenericWebhookHandler {
+	handler := &GenericWebhookHandler{queue: queue, mappings: make(map[string]*GenericMapping, len(mappings))}
+	for i := range mappings {
+		handler.mappings[mappings[i].Name] = &mappings[i]
+	}
+	return handler
+}
+
+func (g *GenericWebhookHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(req.URL.Path, "/generic"), "/")
+	mapping, ok := g.mappings[name]
+	if !ok {
+		http.Error(rw, "Unknown mapping", http.StatusNotFound)
+		log.Printf("Received generic webhook for unknown mapping %q", name)
+		return
+	}
+
+	if len(mapping.Secrets) > 0 {
+		token := req.Header.Get(mapping.SecretHeader)
+		if token == "" {
+			signatureRejections.WithLabelValues("generic", "missing").Inc()
+			http.Error(rw, "Missing "+mapping.SecretHeader+" header", http.StatusUnauthorized)
+			log.Printf("Rejecting generic webhook for mapping %s without secret", name)
+			return
+		}
+		if !verifyToken(token, mapping.Secrets) {
+			signatureRejections.WithLabelValues("generic", "invalid").Inc()
+			http.Error(rw, "Invalid secret", http.StatusUnauthorized)
+			log.Printf("Rejecting generic webhook for mapping %s with invalid secret", name)
+			return
+		}
+	}
+
+	body, err := readBody(rw, req)
+	if err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to read request body: %s", err)
+		return
+	}
+	push, ok, err := mapping.Extract(body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		log.Printf("Failed to extract event of generic mapping %s: %s", name, err)
+		return
+	}
+	if !ok {
+		log.Printf("Ignoring unmapped event kind for generic mapping %s", name)
+		rw.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(rw, "ignored: event kind is not mapped\n")
+		return
+	}
+	log.Printf("Received generic webhook %s for %s, ref %s", name, push.RepositoryURLs[0], push.Ref)
+	notified := BroadcastPush(g.queue, push)
+	fmt.Fprintf(rw, "ok: %d resource(s) notified\n", notified)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/concourse/concourse/atc"
+)
+
+func TestGenericWebhookHandler(t *testing.T) {
+	withResourceCache(t, Pipeline{
+		ID:   1,
+		Name: "pipeline",
+		Team: "main",
+		Resources: []atc.ResourceConfig{
+			{Name: "docs", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/some/repo", "branch": "main", "paths": []interface{}{"docs/"}}},
+			{Name: "all", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/some/repo", "branch": "main"}},
+		},
+	})
+	mapping := GenericMapping{
+		Name:       "tool",
+		Secrets:    []string{"s3cr3t"},
+		Repository: "$.repo.url",
+		Ref:        "$.ref",
+		Files:      "$.changes[*].path",
+		Kind:       "$.kind",
+		Kinds:      map[string]string{"commit": GenericKindBranch},
+	}
+	if err := mapping.compile(); err != nil {
+		t.Fatalf("Failed to compile mapping: %s", err)
+	}
+
+	cases := []struct {
+		path   string
+		token  string
+		body   string
+		Status int
+		Queued int
+	}{
+		{"/generic/tool", "s3cr3t", `{"kind":"commit","repo":{"url":"https://git.foo/some/repo.git"},"ref":"main","changes":[{"path":"docs/a.md"}]}`, 200, 2},
+		{"/generic/tool", "s3cr3t", `{"kind":"commit","repo":{"url":"https://git.foo/some/repo.git"},"ref":"refs/heads/main","changes":[{"path":"src/a.go"}]}`, 200, 1},
+		{"/generic/tool", "s3cr3t", `{"kind":"review","repo":{"url":"https://git.foo/some/repo.git"},"ref":"main"}`, 202, 0},
+		{"/generic/tool", "s3cr3t", `{"kind":"commit","ref":"main"}`, 400, 0},
+		{"/generic/tool", "wrong", `{}`, 401, 0},
+		{"/generic/other", "s3cr3t", `{}`, 404, 0},
+	}
+	for nr, c := range cases {
+		handler := NewGenericWebhookHandler(NewRequestWorkqueue(1), []GenericMapping{mapping})
+		req := httptest.NewRequest("POST", c.path, strings.NewReader(c.body))
+		req.Header.Set("X-Webhook-Token", c.token)
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Code != c.Status || handler.queue.queue.Len() != c.Queued {
+			t.Errorf("Test case %d failed. Got %d, %d queued", nr+1, rw.Code, handler.queue.queue.Len())
+		}
+	}
+}
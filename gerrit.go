@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/concourse/concourse/atc"
+)
+
+// GerritWebhookHandler handles events sent by the gerrit webhooks plugin
+type GerritWebhookHandler struct {
+	queue *RequestWorkqueue
+	//baseURLs are used to resolve gerrit project names to repository urls
+	baseURLs []string
+	//resourceTypes are the resource types tracking gerrit changes
+	resourceTypes []string
+	//secrets are expected as basic auth password or in the Authorization header, the webhooks plugin can't sign events
+	secrets *SecretStore
+}
+
+type gerritEvent struct {
+	Type      string `json:"type"`
+	RefUpdate struct {
+		OldRev  string `json:"oldRev"`
+		NewRev  string `json:"newRev"`
+		RefName string `json:"refName"`
+		Project string `json:"project"`
+	} `json:"refUpdate"`
+	Change struct {
+		Project string `json:"project"`
+		Branch  string `json:"branch"`
+		Number  int    `json:"number"`
+		Status  string `json:"status"`
+	} `json:"change"`
+	PatchSet struct {
+		Number   int    `json:"number"`
+		Revision string `json:"revision"`
+		Ref      string `json:"ref"`
+	} `json:"patchSet"`
+}
+
+func (gr *GerritWebhookHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	body, err := readBody(rw, req)
+	if err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to read request body: %s", err)
+		return
+	}
+	if reason := gr.verifyRequest(req); reason != "" {
+		signatureRejections.WithLabelValues("gerrit", reason).Inc()
+		http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+		log.Printf("Rejecting gerrit webhook: %s credentials", reason)
+		return
+	}
+	var event gerritEvent
+	err = json.Unmarshal(body, &event)
+	if err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to parse request body: %s", err)
+		return
+	}
+
+	switch event.Type {
+	case "ref-updated":
+		gr.handleRefUpdated(rw, event)
+	case "patchset-created":
+		gr.handlePatchsetCreated(rw, event)
+	default:
+		log.Printf("Ignoring unhandled gerrit event %s", event.Type)
+		rw.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(rw, "ignored: event %s is not handled\n", event.Type)
+	}
+}
+
+// verifyRequest checks the basic auth password or Authorization header against the secrets, if any.
+// It returns the reason of the rejection or an empty string.
+func (gr *GerritWebhookHandler) verifyRequest(req *http.Request) string {
+	if gr.secrets.Empty() {
+		return ""
+	}
+	if _, password, ok := req.BasicAuth(); ok {
+		if verifyToken(password, gr.secrets.Secrets("", "")) {
+			return ""
+		}
+		return "invalid"
+	}
+	return verifyAuthorizationHeader(req, gr.secrets.Secrets("", ""))
+}
+
+// projectURLs resolves a gerrit project name against all configured base urls
+func (gr *GerritWebhookHandler) projectURLs(project string) []string {
+	urls := make([]string, 0, 2*len(gr.baseURLs))
+	for _, baseURL := range gr.baseURLs {
+		baseURL = strings.TrimSuffix(baseURL, "/")
+		if !strings.HasPrefix(baseURL, "http://") && !strings.HasPrefix(baseURL, "https://") {
+			urls = append(urls, baseURL+"/"+project)
+			continue
+		}
+		//gerrit serves every project via http with and without the /a/ prefix of authenticated access
+		baseURL = strings.TrimSuffix(baseURL, "/a")
+		urls = append(urls, baseURL+"/"+project, baseURL+"/a/"+project)
+	}
+	return urls
+}
+
+func (gr *GerritWebhookHandler) handleRefUpdated(rw http.ResponseWriter, event gerritEvent) {
+	refUpdate := event.RefUpdate
+	ref := refUpdate.RefName
+	//older gerrit versions send the plain branch name
+	if !strings.HasPrefix(ref, "refs/") {
+		ref = "refs/heads/" + ref
+	}
+	if !strings.HasPrefix(ref, "refs/heads/") {
+		debugf("Skipping gerrit ref-updated event for ref %s in %s", ref, refUpdate.Project)
+		return
+	}
+	if refUpdate.NewRev == zeroSHA {
+		log.Printf("Skipping deletion event for ref %s in %s", ref, refUpdate.Project)
+		return
+	}
+	log.Printf("Received gerrit ref-updated event for %s, ref %s", refUpdate.Project, ref)
+	BroadcastPush(gr.queue, PushEvent{
+		Provider:       "gerrit",
+		RepositoryURLs: gr.projectURLs(refUpdate.Project),
+		Ref:            ref,
+		After:          refUpdate.NewRev,
+		//the webhooks plugin doesn't send changed files, gerrit changes are small so we rather trigger
+		FilesUnknown: true,
+		PathsPolicy:  PathsPolicyTrigger,
+	})
+}
+
+func (gr *GerritWebhookHandler) handlePatchsetCreated(rw http.ResponseWriter, event gerritEvent) {
+	change := event.Change
+	log.Printf("Received gerrit patchset-created event for change %d, patchset %d in %s", change.Number, event.PatchSet.Number, change.Project)
+
+	ScanResourceCache(func(pipeline Pipeline, resource atc.ResourceConfig) bool {
+		if !gr.isGerritResource(resource) {
+			return true
+		}
+		url, _ := resource.Source["url"].(string)
+		if !gr.sameGerrit(url) {
+			return true
+		}
+		query, _ := resource.Source["query"].(string)
+		if !gerritQueryMatches(query, change.Project, change.Branch) {
+			debugf("Skipping resource %s/%s in team %s, query %q doesn't match", pipeline.Ref(), resource.Name, pipeline.Team, query)
+			return true
+		}
+		gr.queue.AddCheck(pipeline, resource, "")
+		return true
+	})
+}
+
+func (gr *GerritWebhookHandler) isGerritResource(resource atc.ResourceConfig) bool {
+	for _, resourceType := range gr.resourceTypes {
+		if resource.Type == resourceType {
+			return true
+		}
+	}
+	return false
+}
+
+// sameGerrit returns true if url points to the host of one of the configured gerrit base urls
+func (gr *GerritWebhookHandler) sameGerrit(url string) bool {
+	host, _, ok := GitRepositoryIdentity(strings.TrimSuffix(url, "/") + "/")
+	if !ok {
+		return false
+	}
+	for _, baseURL := range gr.baseURLs {
+		if baseHost, _, ok := GitRepositoryIdentity(strings.TrimSuffix(baseURL, "/") + "/"); ok && baseHost == host {
+			return true
+		}
+	}
+	return false
+}
+
+// gerritQueryMatches evaluates the project:, branch: and status: terms of a gerrit search query, including
+// negated ones (-term, NOT term). Other terms are ignored, an empty query matches every open change.
+// Queries with OR or parentheses can't be evaluated and match every change.
+func gerritQueryMatches(query, project, branch string) bool {
+	terms, ok := gerritQueryTerms(query)
+	if !ok {
+		return true
+	}
+	for _, term := range terms {
+		if term == "OR" || strings.HasPrefix(strings.TrimLeft(term, "-"), "(") || strings.HasSuffix(term, ")") {
+			return true
+		}
+	}
+	negated := false
+	for _, term := range terms {
+		switch term {
+		case "NOT":
+			negated = !negated
+			continue
+		case "AND":
+			continue
+		}
+		negate := negated
+		negated = false
+		if strings.HasPrefix(term, "-") {
+			negate, term = !negate, term[1:]
+		}
+		if matches, known := gerritTermMatches(term, project, branch); known && matches == negate {
+			return false
+		}
+	}
+	return true
+}
+
+// gerritQueryTerms splits a query into its terms, values quoted with "" or {} may contain spaces.
+// It returns false for unbalanced quotes.
+func gerritQueryTerms(query string) ([]string, bool) {
+	var terms []string
+	var term strings.Builder
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"':
+			quote = '"'
+		case c == '{':
+			quote = '}'
+		case c == ' ' || c == '\t' || c == '\n':
+			if term.Len() > 0 {
+				terms = append(terms, term.String())
+				term.Reset()
+			}
+			continue
+		}
+		term.WriteByte(c)
+	}
+	if quote != 0 {
+		return nil, false
+	}
+	if term.Len() > 0 {
+		terms = append(terms, term.String())
+	}
+	return terms, true
+}
+
+// gerritTermMatches evaluates a single query term for a new patchset of an open change.
+// known is false for terms that can't be evaluated.
+func gerritTermMatches(term, project, branch string) (matches bool, known bool) {
+	parts := strings.SplitN(term, ":", 2)
+	if len(parts) != 2 {
+		return false, false
+	}
+	key, value := parts[0], strings.Trim(parts[1], `"{}`)
+	switch key {
+	case "project", "repo":
+		return value == project, true
+	case "branch":
+		return value == branch || value == "refs/heads/"+branch, true
+	case "status", "is":
+		switch value {
+		case "open", "pending", "new":
+			return true, true
+		case "merged", "abandoned", "closed":
+			return false, true
+		}
+	}
+	return false, false
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/concourse/concourse/atc"
+)
+
+func TestGerritQueryMatches(t *testing.T) {
+	cases := []struct {
+		query  string
+		Result bool
+	}{
+		{"", true},
+		{"status:open", true},
+		{"status:open project:my/project", true},
+		{"project:other/project", false},
+		{"project:my/project branch:master", true},
+		{"project:my/project branch:refs/heads/master", true},
+		{"project:my/project branch:stable", false},
+		{"status:merged", false},
+		{"-status:open", false},
+		{"-status:merged project:my/project", true},
+		{"NOT status:open", false},
+		{"-project:my/project", false},
+		{"-project:other/project branch:master", true},
+		{"NOT branch:master", false},
+		{"NOT NOT branch:master", true},
+		{"project:my/project AND -branch:stable", true},
+		{"-is:wip project:my/project", true},
+		{"project:other/project OR project:third/project", true},
+		{"(project:other/project)", true},
+		{`project:"my/project" branch:master`, true},
+		{`project:"other project" branch:master`, false},
+		{`-project:"other project" branch:master`, true},
+		{`project:{other project}`, false},
+		{`project:other/project message:"fix (typo)"`, false},
+		{`project:other/project -(branch:stable)`, true},
+		{`project:other/project message:"OR"`, false},
+		{`project:"other/project`, true},
+	}
+	for nr, c := range cases {
+		if gerritQueryMatches(c.query, "my/project", "master") != c.Result {
+			t.Errorf("Test case %d failed.", nr+1)
+		}
+	}
+}
+
+func TestGerritWebhookHandler(t *testing.T) {
+	withResourceCache(t, Pipeline{
+		ID:   1,
+		Name: "pipeline",
+		Team: "main",
+		Resources: []atc.ResourceConfig{
+			{Name: "master", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "ssh://ci@gerrit.foo:29418/my/project", "branch": "master"}},
+			{Name: "http", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://gerrit.foo/a/my/project", "branch": "master"}},
+			{Name: "anonymous-http", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://gerrit.foo/my/project", "branch": "master"}},
+			{Name: "changes", Type: "gerrit", WebhookToken: "t", Source: atc.Source{"url": "https://gerrit.foo", "query": "status:open project:my/project"}},
+			{Name: "other-changes", Type: "gerrit", WebhookToken: "t", Source: atc.Source{"url": "https://gerrit.foo", "query": "project:other"}},
+		},
+	})
+
+	cases := []struct {
+		body   string
+		Status int
+		Queued int
+	}{
+		{`{"type":"ref-updated","refUpdate":{"newRev":"abc","refName":"refs/heads/master","project":"my/project"}}`, 200, 3},
+		{`{"type":"ref-updated","refUpdate":{"newRev":"abc","refName":"master","project":"my/project"}}`, 200, 3},
+		{`{"type":"ref-updated","refUpdate":{"newRev":"abc","refName":"refs/changes/01/1/1","project":"my/project"}}`, 200, 0},
+		{`{"type":"patchset-created","change":{"project":"my/project","branch":"master","number":1},"patchSet":{"number":1}}`, 200, 1},
+		{`{"type":"comment-added"}`, 202, 0},
+	}
+	for nr, c := range cases {
+		handler := &GerritWebhookHandler{NewRequestWorkqueue(1), []string{"ssh://git@gerrit.foo:29418", "https://gerrit.foo/a/"}, []string{"gerrit"}, nil}
+		req := httptest.NewRequest("POST", "/gerrit", strings.NewReader(c.body))
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Code != c.Status || handler.queue.queue.Len() != c.Queued {
+			t.Errorf("Test case %d failed. Got %d, %d queued", nr+1, rw.Code, handler.queue.queue.Len())
+		}
+	}
+}
+
+func TestGerritAuthentication(t *testing.T) {
+	withResourceCache(t)
+	secrets, _ := NewSecretStore([]string{"s3cr3t"}, nil)
+	body := `{"type":"comment-added"}`
+
+	cases := []struct {
+		username      string
+		password      string
+		authorization string
+		Status        int
+	}{
+		{"", "", "", 401},
+		{"gerrit", "s3cr3t", "", 202},
+		{"gerrit", "wrong", "", 401},
+		{"", "", "Bearer s3cr3t", 202},
+		{"", "", "s3cr3t", 202},
+		{"", "", "Bearer wrong", 401},
+	}
+	for nr, c := range cases {
+		handler := &GerritWebhookHandler{NewRequestWorkqueue(1), []string{"https://gerrit.foo"}, []string{"gerrit"}, secrets}
+		req := httptest.NewRequest("POST", "/gerrit", strings.NewReader(body))
+		if c.username != "" {
+			req.SetBasicAuth(c.username, c.password)
+		}
+		if c.authorization != "" {
+			req.Header.Set("Authorization", c.authorization)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Code != c.Status {
+			t.Errorf("Test case %d failed. Got %d", nr+1, rw.Code)
+		}
+	}
+}
@@ -1,26 +1,155 @@
 package main
 
 import (
+	"fmt"
+	"net"
+	"net/url"
 	"regexp"
 	"strings"
 )
 
 var (
-	gitURIRegex = regexp.MustCompile(`(https?://|git://|[^@]+@)(?P<host>[-.a-z0-9]+)[:/](?P<repository>.*)`)
+	//scpLikeURIRegex matches the scp-like syntax [user@]host:path, which git uses if there is no slash before the first colon
+	scpLikeURIRegex = regexp.MustCompile(`^(?:[^@/]+@)?(?P<host>[^@/:\[\]]+):(?P<repository>.*)$`)
+
+	//gitURLSchemes are the url schemes of remote git repositories
+	gitURLSchemes = map[string]bool{
+		"http":    true,
+		"https":   true,
+		"git":     true,
+		"ssh":     true,
+		"git+ssh": true,
+		"ssh+git": true,
+	}
+
+	//gitDefaultPorts are the default ports of the url schemes whose port is part of the host.
+	//Ssh ports are ignored, servers commonly serve ssh on a different port than http.
+	gitDefaultPorts = map[string]string{
+		"http":  "80",
+		"https": "443",
+		"git":   "9418",
+	}
+
+	//caseInsensitiveGitHosts are the hosts treating owners and repository names case insensitive
+	caseInsensitiveGitHosts = map[string]bool{
+		"github.com":    true,
+		"gitlab.com":    true,
+		"bitbucket.org": true,
+	}
+
+	//gitHostAliases maps the hosts of a git server to the canonical host it is known by
+	gitHostAliases = map[string]string{}
 )
 
+// addGitHostAliases adds the aliases (alias -> canonical host) to gitHostAliases
+func addGitHostAliases(aliases map[string]string) error {
+	for alias, host := range aliases {
+		alias, host = strings.ToLower(alias), strings.ToLower(host)
+		if alias == "" || host == "" {
+			return fmt.Errorf("Invalid git host alias %s=%s", alias, host)
+		}
+		gitHostAliases[alias] = host
+	}
+	for alias, host := range gitHostAliases {
+		if canonical, ok := gitHostAliases[host]; ok {
+			return fmt.Errorf("Git host alias %s refers to alias %s of %s", alias, host, canonical)
+		}
+	}
+	return nil
+}
+
 func SameGitRepository(url1, url2 string) bool {
 	if url1 == url2 {
 		return true
 	}
-	matches1 := gitURIRegex.FindStringSubmatch(url1)
-	if matches1 == nil {
+	host1, repository1, ok := GitRepositoryIdentity(url1)
+	if !ok {
 		return false
 	}
-	matches2 := gitURIRegex.FindStringSubmatch(url2)
-	if matches2 == nil {
+	host2, repository2, ok := GitRepositoryIdentity(url2)
+	if !ok {
 		return false
 	}
 
-	return matches1[2] == matches2[2] && strings.TrimSuffix(matches1[3], ".git") == strings.TrimSuffix(matches2[3], ".git")
+	return host1 == host2 && repository1 == repository2
+}
+
+// GitRepositoryIdentity returns the canonical host and repository path of a git url.
+// Credentials and ssh or default ports are dropped, the host is lowercased, the path is unescaped and stripped of
+// surrounding slashes and the .git suffix and known host aliases are replaced by their canonical host.
+// Paths are only lowercased for hosts known to be case insensitive.
+func GitRepositoryIdentity(uri string) (host, repository string, ok bool) {
+	uri = strings.TrimSpace(uri)
+	if strings.Contains(uri, "://") {
+		u, err := url.Parse(uri)
+		if err != nil || !gitURLSchemes[strings.ToLower(u.Scheme)] || u.Hostname() == "" {
+			return "", "", false
+		}
+		host, repository = gitURLHost(u), u.Path
+	} else {
+		matches := scpLikeURIRegex.FindStringSubmatch(uri)
+		if matches == nil {
+			return "", "", false
+		}
+		host, repository = matches[1], matches[2]
+		if unescaped, err := url.PathUnescape(repository); err == nil {
+			repository = unescaped
+		}
+	}
+	host = strings.ToLower(host)
+	repository = strings.TrimRight(strings.TrimSuffix(strings.Trim(repository, "/"), ".git"), "/")
+	if azureHost, azureRepository, ok := azureDevOpsIdentity(host, repository); ok {
+		return azureHost, azureRepository, true
+	}
+	host = canonicalGitHost(host)
+	if caseInsensitiveGitHosts[host] {
+		repository = strings.ToLower(repository)
+	}
+	return host, repository, true
+}
+
+// gitURLHost returns the lowercased host of a git url including its port, unless it is the default port of the scheme or a ssh port
+func gitURLHost(u *url.URL) string {
+	host := strings.ToLower(u.Hostname())
+	if defaultPort, ok := gitDefaultPorts[strings.ToLower(u.Scheme)]; ok && u.Port() != "" && u.Port() != defaultPort {
+		return net.JoinHostPort(host, u.Port())
+	}
+	return host
+}
+
+// canonicalGitHost returns the lowercased host or the canonical host if it is an alias
+func canonicalGitHost(host string) string {
+	host = strings.ToLower(host)
+	if alias, ok := gitHostAliases[host]; ok {
+		return alias
+	}
+	return host
+}
+
+// azureDevOpsIdentity maps the different azure devops url forms to dev.azure.com/org/project/repo:
+//
+//	https://dev.azure.com/org/project/_git/repo
+//	https://org.visualstudio.com/[DefaultCollection/]project/_git/repo
+//	org@vs-ssh.visualstudio.com:v3/org/project/repo
+//	git@ssh.dev.azure.com:v3/org/project/repo
+func azureDevOpsIdentity(host, repository string) (string, string, bool) {
+	parts := strings.Split(repository, "/")
+	switch {
+	case host == "dev.azure.com" && len(parts) == 4 && parts[2] == "_git":
+		parts = []string{parts[0], parts[1], parts[3]}
+	case strings.HasSuffix(host, ".visualstudio.com") && host != "vs-ssh.visualstudio.com":
+		if len(parts) == 4 && parts[0] == "DefaultCollection" {
+			parts = parts[1:]
+		}
+		if len(parts) != 3 || parts[1] != "_git" {
+			return "", "", false
+		}
+		parts = []string{strings.TrimSuffix(host, ".visualstudio.com"), parts[0], parts[2]}
+	case (host == "ssh.dev.azure.com" || host == "vs-ssh.visualstudio.com") && len(parts) == 4 && parts[0] == "v3":
+		parts = parts[1:]
+	default:
+		return "", "", false
+	}
+	//azure devops names are case insensitive
+	return "dev.azure.com", strings.ToLower(strings.Join(parts, "/")), true
 }
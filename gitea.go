@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// GiteaWebhookHandler handles webhooks of gitea and forgejo
+type GiteaWebhookHandler struct {
+	queue   *RequestWorkqueue
+	secrets *SecretStore
+}
+
+type giteaPushEvent struct {
+	Ref        string `json:"ref"`
+	Before     string `json:"before"`
+	After      string `json:"after"`
+	CompareURL string `json:"compare_url"`
+	//TotalCommits is the number of pushed commits, gitea truncates the commits to its webhook payload limit
+	TotalCommits int `json:"total_commits"`
+	Repository   struct {
+		FullName      string `json:"full_name"`
+		CloneURL      string `json:"clone_url"`
+		SSHURL        string `json:"ssh_url"`
+		DefaultBranch string `json:"default_branch"`
+	} `json:"repository"`
+	Commits []struct {
+		ID            string   `json:"id"`
+		Message       string   `json:"message"`
+		AddedFiles    []string `json:"added"`
+		RemovedFiles  []string `json:"removed"`
+		ModifiedFiles []string `json:"modified"`
+	} `json:"commits"`
+}
+
+// giteaHeader returns the value of a forgejo header falling back to the gitea one
+func giteaHeader(req *http.Request, name string) string {
+	if value := req.Header.Get("X-Forgejo-" + name); value != "" {
+		return value
+	}
+	return req.Header.Get("X-Gitea-" + name)
+}
+
+func (gt *GiteaWebhookHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	body, err := readBody(rw, req)
+	if err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to read request body: %s", err)
+		return
+	}
+	var pushEvent giteaPushEvent
+	err = json.Unmarshal(body, &pushEvent)
+	if err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to parse request body: %s", err)
+		return
+	}
+
+	if !gt.secrets.Empty() {
+		host, repository, _ := GitRepositoryIdentity(pushEvent.Repository.CloneURL)
+		signature := giteaHeader(req, "Signature")
+		if signature == "" {
+			signatureRejections.WithLabelValues("gitea", "missing").Inc()
+			http.Error(rw, "Missing X-Gitea-Signature header", http.StatusUnauthorized)
+			log.Printf("Rejecting unsigned gitea webhook for %s", pushEvent.Repository.CloneURL)
+			return
+		}
+		//gitea sends the plain hex digest without a sha256= prefix
+		if !verifyHMAC(sha256.New, "", signature, body, gt.secrets.Secrets(host, repository)) {
+			signatureRejections.WithLabelValues("gitea", "invalid").Inc()
+			http.Error(rw, "Invalid signature", http.StatusUnauthorized)
+			log.Printf("Rejecting gitea webhook with invalid signature for %s", pushEvent.Repository.CloneURL)
+			return
+		}
+	}
+
+	event := giteaHeader(req, "Event")
+	switch event {
+	case "push":
+	case "create", "delete":
+		log.Printf("Received %s event in %s, handled by the corresponding push event", event, pushEvent.Repository.CloneURL)
+		fmt.Fprintf(rw, "ok: %s events are handled by the corresponding push event\n", event)
+		return
+	default:
+		log.Printf("Ignoring unhandled gitea event %s for %s", event, pushEvent.Repository.CloneURL)
+		rw.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(rw, "ignored: event %s is not handled\n", event)
+		return
+	}
+
+	for _, push := range pushEvent.pushEvents() {
+		BroadcastPush(gt.queue, push)
+	}
+}
+
+// parseGiteaPush returns the push event of a gitea push payload, deletions are skipped
+func parseGiteaPush(payload []byte) ([]PushEvent, error) {
+	var pushEvent giteaPushEvent
+	if err := json.Unmarshal(payload, &pushEvent); err != nil {
+		return nil, err
+	}
+	return pushEvent.pushEvents(), nil
+}
+
+func (pushEvent giteaPushEvent) pushEvents() []PushEvent {
+	if pushEvent.After == zeroSHA {
+		log.Printf("Skipping deletion event for ref %s in %s", pushEvent.Ref, pushEvent.Repository.CloneURL)
+		return nil
+	}
+	log.Printf("Received gitea webhook for %s, ref %s, %s", pushEvent.Repository.CloneURL, pushEvent.Ref, pushEvent.CompareURL)
+
+	push := PushEvent{
+		Provider:       "gitea",
+		RepositoryURLs: []string{pushEvent.Repository.CloneURL, pushEvent.Repository.SSHURL},
+		Ref:            pushEvent.Ref,
+		After:          pushEvent.After,
+		DefaultBranch:  pushEvent.Repository.DefaultBranch,
+	}
+	//collect list of commits and changed files
+	for _, commit := range pushEvent.Commits {
+		files := append(append(append([]string{}, commit.AddedFiles...), commit.RemovedFiles...), commit.ModifiedFiles...)
+		push.Commits = append(push.Commits, PushCommit{ID: commit.ID, Message: commit.Message, Files: files})
+		push.FilesChanged = append(push.FilesChanged, files...)
+	}
+	push.Incomplete = incompletePushReason(push, pushEvent.Before == zeroSHA, false, pushEvent.TotalCommits, 0, 0)
+	return []PushEvent{push}
+}
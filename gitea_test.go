@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/concourse/concourse/atc"
+)
+
+func TestGiteaWebhookHandler(t *testing.T) {
+	withResourceCache(t, Pipeline{
+		ID:   1,
+		Name: "pipeline",
+		Team: "main",
+		Resources: []atc.ResourceConfig{
+			{Name: "docs", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "git@forgejo.foo:org/repo.git", "paths": []interface{}{"docs"}}},
+			{Name: "src", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://forgejo.foo/org/repo", "paths": []interface{}{"src"}}},
+			{Name: "release", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://forgejo.foo/org/repo", "branch": "release"}},
+		},
+	})
+	body := `{"ref":"refs/heads/main","after":"abc","repository":{"clone_url":"https://forgejo.foo/org/repo.git","ssh_url":"git@forgejo.foo:org/repo.git","default_branch":"main"},"commits":[{"id":"abc","modified":["docs/index.md"]}]}`
+	signature := "52cea175eb627bc62a21abd83d10c5b00844d008148f22df5b47b97dfa2ecab9"
+
+	cases := []struct {
+		headers map[string]string
+		Status  int
+		Queued  int
+	}{
+		{map[string]string{"X-Gitea-Event": "push", "X-Gitea-Signature": signature}, 200, 1},
+		{map[string]string{"X-Forgejo-Event": "push", "X-Forgejo-Signature": signature}, 200, 1},
+		{map[string]string{"X-Forgejo-Event": "push", "X-Forgejo-Signature": "00" + signature[2:]}, 401, 0},
+		{map[string]string{"X-Gitea-Event": "push"}, 401, 0},
+		{map[string]string{"X-Gitea-Event": "issues", "X-Gitea-Signature": signature}, 202, 0},
+	}
+	secrets, _ := NewSecretStore([]string{"s3cr3t"}, nil)
+	for nr, c := range cases {
+		handler := &GiteaWebhookHandler{NewRequestWorkqueue(1), secrets}
+		req := httptest.NewRequest("POST", "/gitea", strings.NewReader(body))
+		for k, v := range c.headers {
+			req.Header.Set(k, v)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Code != c.Status || handler.queue.queue.Len() != c.Queued {
+			t.Errorf("Test case %d failed. Got %d, %d queued", nr+1, rw.Code, handler.queue.queue.Len())
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type GithubWebhookHandler struct {
+	queue   *RequestWorkqueue
+	secrets *SecretStore
+	//api is used to look up the changed files of pull requests, it is nil if no token is configured
+	api *GithubAPI
+	//incompletePushPolicy decides about pushes whose commits don't cover all changes
+	incompletePushPolicy string
+}
+
+type githubRepository struct {
+	FullName      string `json:"full_name"`
+	CloneURL      string `json:"clone_url"`
+	GitURL        string `json:"git_url"`
+	SSHURL        string `json:"ssh_url"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+func (gh *GithubWebhookHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	body, err := readBody(rw, req)
+	if err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to read request body: %s", err)
+		return
+	}
+	//the repository selects the scoped secrets, a malformed body is only reported once the request is authenticated
+	payload, parseErr := githubPayload(req.Header.Get("Content-Type"), body)
+	var envelope struct {
+		Repository githubRepository `json:"repository"`
+	}
+	if parseErr == nil {
+		parseErr = json.Unmarshal(payload, &envelope)
+	}
+
+	if !gh.secrets.Empty() {
+		host, repository, _ := GitRepositoryIdentity(envelope.Repository.CloneURL)
+		if enterpriseHost := req.Header.Get("X-GitHub-Enterprise-Host"); enterpriseHost != "" {
+			host = canonicalGitHost(enterpriseHost)
+		}
+		signature := req.Header.Get("X-Hub-Signature-256")
+		if signature == "" {
+			signatureRejections.WithLabelValues("github", "missing").Inc()
+			http.Error(rw, "Missing X-Hub-Signature-256 header", http.StatusUnauthorized)
+			log.Printf("Rejecting unsigned webhook for %s", envelope.Repository.CloneURL)
+			return
+		}
+		//the signature is calculated over the raw body, also for form encoded deliveries
+		if !verifySHA256Signature(signature, body, gh.secrets.Secrets(host, repository)) {
+			signatureRejections.WithLabelValues("github", "invalid").Inc()
+			http.Error(rw, "Invalid signature", http.StatusUnauthorized)
+			log.Printf("Rejecting webhook with invalid signature for %s", envelope.Repository.CloneURL)
+			return
+		}
+	}
+	if parseErr != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to parse request body: %s", parseErr)
+		return
+	}
+
+	event := req.Header.Get("X-GitHub-Event")
+	if event == "" {
+		//keep accepting deliveries from tools that don't set the event header
+		debugf("No X-GitHub-Event header given, assuming push event")
+		event = "push"
+	}
+
+	switch event {
+	case "push":
+		gh.handlePush(rw, payload)
+	case "ping":
+		gh.handlePing(rw, payload)
+	case "create", "delete":
+		gh.handleRefEvent(rw, event, payload)
+	case "pull_request", "pull_request_review":
+		gh.handlePullRequest(rw, event, payload)
+	case "release":
+		gh.handleRelease(rw, payload)
+	default:
+		log.Printf("Ignoring unhandled github event %s for %s", event, envelope.Repository.CloneURL)
+		rw.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(rw, "ignored: event %s is not handled\n", event)
+	}
+}
+
+// githubPayload returns the json payload of a delivery which is either sent as json or form encoded
+func githubPayload(contentType string, body []byte) ([]byte, error) {
+	if !strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		return body, nil
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	payload := values.Get("payload")
+	if payload == "" {
+		return nil, fmt.Errorf("Form encoded request without payload")
+	}
+	return []byte(payload), nil
+}
+
+func (gh *GithubWebhookHandler) handlePing(rw http.ResponseWriter, payload []byte) {
+	var pingEvent struct {
+		Zen        string           `json:"zen"`
+		HookID     int              `json:"hook_id"`
+		Repository githubRepository `json:"repository"`
+	}
+	if err := json.Unmarshal(payload, &pingEvent); err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to parse ping event: %s", err)
+		return
+	}
+	if pingEvent.Repository.CloneURL == "" {
+		log.Printf("Received ping for hook %d", pingEvent.HookID)
+		fmt.Fprintf(rw, "pong\n")
+		return
+	}
+	count := countRepositoryResources(pingEvent.Repository.CloneURL)
+	log.Printf("Received ping for hook %d of %s. %d resource(s) reference this repository", pingEvent.HookID, pingEvent.Repository.CloneURL, count)
+	fmt.Fprintf(rw, "pong: %d resource(s) reference %s\n", count, pingEvent.Repository.FullName)
+}
+
+// handleRefEvent handles create and delete events. Github sends a push event
+// for each of them as well, so nothing is triggered here to avoid duplicate checks.
+func (gh *GithubWebhookHandler) handleRefEvent(rw http.ResponseWriter, event string, payload []byte) {
+	var refEvent struct {
+		Ref        string           `json:"ref"`
+		RefType    string           `json:"ref_type"`
+		Repository githubRepository `json:"repository"`
+	}
+	if err := json.Unmarshal(payload, &refEvent); err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to parse %s event: %s", event, err)
+		return
+	}
+	log.Printf("Received %s event for %s %s in %s, handled by the corresponding push event", event, refEvent.RefType, refEvent.Ref, refEvent.Repository.CloneURL)
+	fmt.Fprintf(rw, "ok: %s events are handled by the corresponding push event\n", event)
+}
+
+func (gh *GithubWebhookHandler) handlePush(rw http.ResponseWriter, payload []byte) {
+	pushes, err := parseGithubPush(payload)
+	if err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to parse request body: %s", err)
+		return
+	}
+	for _, push := range pushes {
+		if push.Incomplete != "" && gh.incompletePushPolicy == IncompletePushPolicyCompare {
+			gh.comparePush(&push, payload)
+		}
+		BroadcastPush(gh.queue, push)
+	}
+}
+
+// comparePush replaces the changed files of an incomplete push with the ones of the github compare api.
+// The push stays incomplete if the comparison fails.
+func (gh *GithubWebhookHandler) comparePush(push *PushEvent, payload []byte) {
+	if gh.api == nil {
+		return
+	}
+	compareURL, err := githubCompareURL(payload)
+	if err != nil || compareURL == "" {
+		log.Printf("Can't compare push of %s to %s, %s", push.After, push.Ref, push.Incomplete)
+		return
+	}
+	files, err := gh.api.CompareFiles(compareURL)
+	if err != nil {
+		log.Printf("Failed to compare push of %s to %s: %s", push.After, push.Ref, err)
+		return
+	}
+	log.Printf("Looked up %d changed files of push of %s to %s, %s", len(files), push.After, push.Ref, push.Incomplete)
+	//the commits of the comparison don't carry their files, so only the changed files are evaluated
+	push.FilesChanged, push.Commits, push.Incomplete = files, nil, ""
+}
+
+// githubCompareURL returns the api url comparing the pushed commit to the previous one,
+// or to the default branch if the ref was created. It is empty if there is nothing to compare with.
+func githubCompareURL(payload []byte) (string, error) {
+	var pushEvent struct {
+		Ref        string `json:"ref"`
+		Before     string `json:"before"`
+		After      string `json:"after"`
+		Created    bool   `json:"created"`
+		Repository struct {
+			CompareURL    string `json:"compare_url"`
+			DefaultBranch string `json:"default_branch"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(payload, &pushEvent); err != nil {
+		return "", err
+	}
+	base := pushEvent.Before
+	if pushEvent.Created || base == zeroSHA {
+		if pushEvent.Repository.DefaultBranch == "" || pushEvent.Ref == "refs/heads/"+pushEvent.Repository.DefaultBranch {
+			return "", nil
+		}
+		base = pushEvent.Repository.DefaultBranch
+	}
+	if pushEvent.Repository.CompareURL == "" || base == "" {
+		return "", nil
+	}
+	return strings.NewReplacer("{base}", url.PathEscape(base), "{head}", pushEvent.After).Replace(pushEvent.Repository.CompareURL), nil
+}
+
+// github truncates the commits and changed files of push payloads
+const (
+	githubMaxPushCommits = 20
+	githubMaxPushFiles   = 3000
+)
+
+// parseGithubPush returns the push event of a github push payload, deletions are skipped
+func parseGithubPush(payload []byte) ([]PushEvent, error) {
+	var pushEvent struct {
+		Ref        string           `json:"ref"`
+		Before     string           `json:"before"`
+		After      string           `json:"after"`
+		Created    bool             `json:"created"`
+		Forced     bool             `json:"forced"`
+		CompareURL string           `json:"compare"`
+		Repository githubRepository `json:"repository"`
+		Commits    []struct {
+			ID            string   `json:"id"`
+			Message       string   `json:"message"`
+			AddedFiles    []string `json:"added"`
+			RemovedFiles  []string `json:"removed"`
+			ModifiedFiles []string `json:"modified"`
+		} `json:"commits"`
+	}
+	err := json.Unmarshal(payload, &pushEvent)
+	if err != nil {
+		return nil, err
+	}
+
+	if pushEvent.After == zeroSHA {
+		log.Printf("Skipping deletion event for ref %s in %s", pushEvent.Ref, pushEvent.Repository.CloneURL)
+		return nil, nil
+	}
+	log.Printf("Received webhhook for %s, ref %s, %s", pushEvent.Repository.CloneURL, pushEvent.Ref, pushEvent.CompareURL)
+
+	push := PushEvent{
+		Provider:       "github",
+		RepositoryURLs: []string{pushEvent.Repository.CloneURL, pushEvent.Repository.SSHURL},
+		Ref:            pushEvent.Ref,
+		After:          pushEvent.After,
+		DefaultBranch:  pushEvent.Repository.DefaultBranch,
+	}
+	//collect list of commits and changed files
+	for _, commit := range pushEvent.Commits {
+		files := append(append(append([]string{}, commit.AddedFiles...), commit.RemovedFiles...), commit.ModifiedFiles...)
+		push.Commits = append(push.Commits, PushCommit{ID: commit.ID, Message: commit.Message, Files: files})
+		push.FilesChanged = append(push.FilesChanged, files...)
+	}
+	push.Incomplete = incompletePushReason(push, pushEvent.Created, pushEvent.Forced, 0, githubMaxPushCommits, githubMaxPushFiles)
+	return []PushEvent{push}, nil
+}
+
+// githubPullRequestActions are the pull_request actions that can produce a new version of a pull request resource
+var githubPullRequestActions = map[string]bool{
+	"opened":           true,
+	"synchronize":      true,
+	"reopened":         true,
+	"ready_for_review": true,
+	"labeled":          true,
+}
+
+func (gh *GithubWebhookHandler) handlePullRequest(rw http.ResponseWriter, event string, payload []byte) {
+	pr, pullRequestURL, err := parseGithubPullRequest(event, payload)
+	if err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to parse %s event: %s", event, err)
+		return
+	}
+	if pr == nil {
+		rw.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(rw, "ignored: action is not handled\n")
+		return
+	}
+	if gh.api != nil && !pr.Approved && pr.Action != "labeled" {
+		files, err := gh.api.PullRequestFiles(pullRequestURL)
+		if err != nil {
+			log.Printf("Failed to get changed files of pull request #%d in %s: %s", pr.Number, pr.RepositoryName, err)
+		} else {
+			pr.FilesChanged = files
+			pr.FilesUnknown = false
+		}
+	}
+	BroadcastPullRequest(gh.queue, *pr)
+}
+
+// parseGithubPullRequest returns the pull request event of a pull_request or pull_request_review payload and the api url
+// of the pull request. It returns nil if the action can't produce a new version.
+func parseGithubPullRequest(event string, payload []byte) (*PullRequestEvent, string, error) {
+	var prEvent struct {
+		Action      string `json:"action"`
+		Number      int    `json:"number"`
+		PullRequest struct {
+			URL    string `json:"url"`
+			Number int    `json:"number"`
+			Labels []struct {
+				Name string `json:"name"`
+			} `json:"labels"`
+			Base struct {
+				Ref  string           `json:"ref"`
+				Repo githubRepository `json:"repo"`
+			} `json:"base"`
+			Head struct {
+				Ref  string           `json:"ref"`
+				Repo githubRepository `json:"repo"`
+			} `json:"head"`
+		} `json:"pull_request"`
+		Review struct {
+			State string `json:"state"`
+		} `json:"review"`
+		Repository githubRepository `json:"repository"`
+	}
+	if err := json.Unmarshal(payload, &prEvent); err != nil {
+		return nil, "", err
+	}
+	pullRequest := prEvent.PullRequest
+	repository := pullRequest.Base.Repo
+	if repository.CloneURL == "" {
+		repository = prEvent.Repository
+	}
+
+	approved := false
+	switch {
+	case event == "pull_request_review":
+		if prEvent.Action != "submitted" || !strings.EqualFold(prEvent.Review.State, "approved") {
+			log.Printf("Ignoring review %s (%s) of pull request #%d in %s", prEvent.Action, prEvent.Review.State, pullRequest.Number, repository.FullName)
+			return nil, "", nil
+		}
+		approved = true
+	case !githubPullRequestActions[prEvent.Action]:
+		log.Printf("Ignoring action %s of pull request #%d in %s", prEvent.Action, pullRequest.Number, repository.FullName)
+		return nil, "", nil
+	}
+	log.Printf("Received %s event (%s) for pull request #%d in %s", event, prEvent.Action, pullRequest.Number, repository.FullName)
+
+	pr := &PullRequestEvent{
+		Provider:       "github",
+		RepositoryURLs: []string{repository.CloneURL, repository.SSHURL},
+		RepositoryName: repository.FullName,
+		Number:         pullRequest.Number,
+		Action:         prEvent.Action,
+		BaseBranch:     pullRequest.Base.Ref,
+		Fork:           pullRequest.Head.Repo.FullName != repository.FullName,
+		Approved:       approved,
+		FilesUnknown:   true,
+		PathsPolicy:    PathsPolicyTrigger,
+	}
+	for _, label := range pullRequest.Labels {
+		pr.Labels = append(pr.Labels, label.Name)
+	}
+	return pr, pullRequest.URL, nil
+}
+
+// githubReleaseActions are the release actions that can produce a new version of a github-release resource
+var githubReleaseActions = map[string]bool{
+	"published":   true,
+	"prereleased": true,
+	"edited":      true,
+}
+
+func (gh *GithubWebhookHandler) handleRelease(rw http.ResponseWriter, payload []byte) {
+	release, err := parseGithubRelease(payload)
+	if err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to parse release event: %s", err)
+		return
+	}
+	if release == nil {
+		rw.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(rw, "ignored: action is not handled\n")
+		return
+	}
+	BroadcastRelease(gh.queue, *release)
+}
+
+// parseGithubRelease returns the release event of a release payload. It returns nil if the action can't produce a new version.
+func parseGithubRelease(payload []byte) (*ReleaseEvent, error) {
+	var releaseEvent struct {
+		Action  string `json:"action"`
+		Release struct {
+			TagName    string `json:"tag_name"`
+			Draft      bool   `json:"draft"`
+			Prerelease bool   `json:"prerelease"`
+		} `json:"release"`
+		Repository githubRepository `json:"repository"`
+	}
+	if err := json.Unmarshal(payload, &releaseEvent); err != nil {
+		return nil, err
+	}
+	//github sends created instead of published when a draft is saved
+	draftCreated := releaseEvent.Action == "created" && releaseEvent.Release.Draft
+	if !githubReleaseActions[releaseEvent.Action] && !draftCreated {
+		log.Printf("Ignoring action %s of release %s in %s", releaseEvent.Action, releaseEvent.Release.TagName, releaseEvent.Repository.FullName)
+		return nil, nil
+	}
+	log.Printf("Received release event (%s) for %s in %s", releaseEvent.Action, releaseEvent.Release.TagName, releaseEvent.Repository.FullName)
+	return &ReleaseEvent{
+		Provider:       "github",
+		RepositoryURLs: []string{releaseEvent.Repository.CloneURL, releaseEvent.Repository.SSHURL},
+		RepositoryName: releaseEvent.Repository.FullName,
+		Action:         releaseEvent.Action,
+		Tag:            releaseEvent.Release.TagName,
+		Draft:          releaseEvent.Release.Draft,
+		Prerelease:     releaseEvent.Release.Prerelease,
+	}, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// githubMaxFilePages is the number of pages github returns at most when listing the files of a pull request (3000 files)
+const githubMaxFilePages = 30
+
+// GithubAPI is a minimal github api client used to look up data missing in webhook payloads
+type GithubAPI struct {
+	token      string
+	httpClient *http.Client
+}
+
+func NewGithubAPI(token string) *GithubAPI {
+	return &GithubAPI{
+		token:      token,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// PullRequestFiles returns the files changed by a pull request, pullRequestURL is the api url of the pull request
+func (api *GithubAPI) PullRequestFiles(pullRequestURL string) ([]string, error) {
+	var files []string
+	for page := 1; page <= githubMaxFilePages; page++ {
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/files?per_page=100&page=%d", pullRequestURL, page), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/vnd.github+json")
+		req.Header.Set("Authorization", "token "+api.token)
+		response, err := api.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		var pageFiles []struct {
+			Filename         string `json:"filename"`
+			PreviousFilename string `json:"previous_filename"`
+		}
+		err = json.NewDecoder(response.Body).Decode(&pageFiles)
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("Listing files of %s failed: %s", pullRequestURL, response.Status)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range pageFiles {
+			files = append(files, file.Filename)
+			if file.PreviousFilename != "" {
+				files = append(files, file.PreviousFilename)
+			}
+		}
+		if len(pageFiles) < 100 {
+			break
+		}
+	}
+	return files, nil
+}
+
+// githubMaxCompareFiles is the number of files github lists at most when comparing commits
+const githubMaxCompareFiles = 300
+
+// CompareFiles returns the files changed between the base and head of a comparison, compareURL is the api url of the comparison
+func (api *GithubAPI) CompareFiles(compareURL string) ([]string, error) {
+	req, err := http.NewRequest("GET", compareURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "token "+api.token)
+	response, err := api.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Comparing %s failed: %s", compareURL, response.Status)
+	}
+	var comparison struct {
+		Files []struct {
+			Filename         string `json:"filename"`
+			PreviousFilename string `json:"previous_filename"`
+		} `json:"files"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&comparison); err != nil {
+		return nil, err
+	}
+	if len(comparison.Files) >= githubMaxCompareFiles {
+		return nil, fmt.Errorf("Comparing %s lists more than %d files", compareURL, githubMaxCompareFiles)
+	}
+	var files []string
+	for _, file := range comparison.Files {
+		files = append(files, file.Filename)
+		if file.PreviousFilename != "" {
+			files = append(files, file.PreviousFilename)
+		}
+	}
+	return files, nil
+}
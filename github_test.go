@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/concourse/concourse/atc"
+)
+
+func TestGithubPayload(t *testing.T) {
+	cases := []struct {
+		contentType string
+		body        string
+		Result      string
+		Error       bool
+	}{
+		{"application/json", `{"zen":"x"}`, `{"zen":"x"}`, false},
+		{"", `{"zen":"x"}`, `{"zen":"x"}`, false},
+		{"application/x-www-form-urlencoded", `payload=%7B%22zen%22%3A%22x%22%7D`, `{"zen":"x"}`, false},
+		{"application/x-www-form-urlencoded", `other=1`, "", true},
+	}
+	for nr, c := range cases {
+		result, err := githubPayload(c.contentType, []byte(c.body))
+		if (err != nil) != c.Error || string(result) != c.Result {
+			t.Errorf("Test case %d failed. Got %q, %v", nr+1, result, err)
+		}
+	}
+}
+
+func TestGithubEventDispatch(t *testing.T) {
+	cases := []struct {
+		event  string
+		body   string
+		Status int
+		Result string
+	}{
+		{"ping", `{"hook_id":1,"repository":{"full_name":"some/repo","clone_url":"https://git.foo/some/repo.git"}}`, 200, "pong: 0 resource(s) reference some/repo\n"},
+		{"watch", `{"repository":{"clone_url":"https://git.foo/some/repo.git"}}`, 202, "ignored: event watch is not handled\n"},
+		{"create", `{"ref":"v1","ref_type":"tag","repository":{"clone_url":"https://git.foo/some/repo.git"}}`, 200, "ok: create events are handled by the corresponding push event\n"},
+		{"ping", `not json`, 400, ""},
+		{"pull_request", `{"action":"closed","pull_request":{"number":1,"base":{"ref":"master","repo":{"clone_url":"https://git.foo/some/repo.git"}}}}`, 202, "ignored: action is not handled\n"},
+		{"pull_request_review", `{"action":"submitted","review":{"state":"commented"},"pull_request":{"number":1}}`, 202, "ignored: action is not handled\n"},
+		{"release", `{"action":"deleted","release":{"tag_name":"v1.0.0"}}`, 202, "ignored: action is not handled\n"},
+		{"release", `{"action":"published","release":{"tag_name":"v1.0.0"},"repository":{"full_name":"some/repo","clone_url":"https://github.com/some/repo.git"}}`, 200, ""},
+	}
+	handler := &GithubWebhookHandler{}
+	for nr, c := range cases {
+		req := httptest.NewRequest("POST", "/github", strings.NewReader(c.body))
+		req.Header.Set("X-GitHub-Event", c.event)
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Code != c.Status || rw.Body.String() != c.Result {
+			t.Errorf("Test case %d failed. Got %d %q", nr+1, rw.Code, rw.Body.String())
+		}
+	}
+}
+
+func TestGithubSignatureRejection(t *testing.T) {
+	secrets, _ := NewSecretStore([]string{"s3cr3t"}, nil)
+	handler := &GithubWebhookHandler{secrets: secrets}
+	//malformed bodies are rejected as unauthenticated, not as bad requests
+	for _, body := range []string{`{"zen":"x"}`, `not json`} {
+		req := httptest.NewRequest("POST", "/github", strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "ping")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Code != http.StatusUnauthorized {
+			t.Errorf("Expected unsigned request %q to be rejected, got %d", body, rw.Code)
+		}
+	}
+}
+
+func TestGithubPullRequest(t *testing.T) {
+	withResourceCache(t, Pipeline{
+		ID:   1,
+		Name: "pipeline",
+		Team: "main",
+		Resources: []atc.ResourceConfig{
+			{Name: "prs", Type: "pull-request", WebhookToken: "t", Source: atc.Source{"repository": "some/repo", "v3_endpoint": "https://git.foo/api/v3"}},
+			{Name: "approved-prs", Type: "pull-request", WebhookToken: "t", Source: atc.Source{"repository": "some/repo", "v3_endpoint": "https://git.foo/api/v3", "required_review_approvals": 1}},
+			{Name: "master", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/some/repo.git"}},
+		},
+	})
+	pullRequest := `"pull_request":{"number":1,"base":{"ref":"master","repo":{"full_name":"some/repo","clone_url":"https://git.foo/some/repo.git"}},"head":{"ref":"feature","repo":{"full_name":"some/repo"}}}`
+
+	cases := []struct {
+		event  string
+		body   string
+		Queued int
+	}{
+		{"pull_request", `{"action":"opened",` + pullRequest + `}`, 1},
+		{"pull_request", `{"action":"synchronize",` + pullRequest + `}`, 2},
+		{"pull_request_review", `{"action":"submitted","review":{"state":"APPROVED"},` + pullRequest + `}`, 1},
+		{"push", `{"ref":"refs/heads/master","after":"abc","repository":{"full_name":"some/repo","clone_url":"https://git.foo/some/repo.git","default_branch":"master"}}`, 1},
+	}
+	for nr, c := range cases {
+		handler := &GithubWebhookHandler{queue: NewRequestWorkqueue(1)}
+		req := httptest.NewRequest("POST", "/github", strings.NewReader(c.body))
+		req.Header.Set("X-GitHub-Event", c.event)
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if handler.queue.queue.Len() != c.Queued {
+			t.Errorf("Test case %d failed. Got %d queued", nr+1, handler.queue.queue.Len())
+		}
+	}
+}
+
+func TestGithubIncompletePush(t *testing.T) {
+	withResourceCache(t, Pipeline{
+		ID:   1,
+		Name: "pipeline",
+		Team: "main",
+		Resources: []atc.ResourceConfig{
+			{Name: "charts", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/some/repo.git", "branch": "release", "paths": []interface{}{"charts/"}}},
+		},
+	})
+	var compared []string
+	api := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		compared = append(compared, req.URL.Path)
+		switch {
+		case strings.HasSuffix(req.URL.Path, "...charts"):
+			fmt.Fprint(rw, `{"files":[{"filename":"charts/values.yaml"}]}`)
+		case strings.HasSuffix(req.URL.Path, "...docs"):
+			fmt.Fprint(rw, `{"files":[{"filename":"README.md","previous_filename":"charts/README.md"}]}`)
+		default:
+			http.Error(rw, "not found", http.StatusNotFound)
+		}
+	}))
+	defer api.Close()
+
+	push := func(after, before string, created, forced bool, commits int) string {
+		commitList := make([]string, commits)
+		for i := range commitList {
+			commitList[i] = `{"id":"x","message":"Update docs","modified":["README.md"]}`
+		}
+		return fmt.Sprintf(`{"ref":"refs/heads/release","before":%q,"after":%q,"created":%v,"forced":%v,"commits":[%s],"repository":{"full_name":"some/repo","clone_url":"https://git.foo/some/repo.git","default_branch":"main","compare_url":"%s/repos/some/repo/compare/{base}...{head}"}}`,
+			before, after, created, forced, strings.Join(commitList, ","), api.URL)
+	}
+
+	cases := []struct {
+		policy   string
+		body     string
+		Queued   int
+		Compared string
+	}{
+		{IncompletePushPolicyTrigger, push("docs", "old", false, false, 1), 0, ""},
+		{IncompletePushPolicyTrigger, push("docs", zeroSHA, true, false, 1), 1, ""},
+		{IncompletePushPolicyTrigger, push("docs", "old", false, true, 1), 1, ""},
+		{IncompletePushPolicyTrigger, push("docs", "old", false, false, 0), 1, ""},
+		{IncompletePushPolicyTrigger, push("docs", "old", false, false, githubMaxPushCommits), 1, ""},
+		{IncompletePushPolicyCompare, push("docs", "old", false, false, 1), 0, ""},
+		{IncompletePushPolicyCompare, push("charts", zeroSHA, true, false, 1), 1, "/repos/some/repo/compare/main...charts"},
+		{IncompletePushPolicyCompare, push("docs", zeroSHA, true, false, 1), 1, "/repos/some/repo/compare/main...docs"},
+		{IncompletePushPolicyCompare, push("docs", "old", false, true, 1), 1, "/repos/some/repo/compare/old...docs"},
+		{IncompletePushPolicyCompare, push("charts", "old", false, false, githubMaxPushCommits), 1, "/repos/some/repo/compare/old...charts"},
+		{IncompletePushPolicyCompare, push("unknown", "old", false, true, 1), 1, "/repos/some/repo/compare/old...unknown"},
+	}
+	for nr, c := range cases {
+		compared = nil
+		handler := &GithubWebhookHandler{queue: NewRequestWorkqueue(1), api: NewGithubAPI("token"), incompletePushPolicy: c.policy}
+		req := httptest.NewRequest("POST", "/github", strings.NewReader(c.body))
+		req.Header.Set("X-GitHub-Event", "push")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if handler.queue.queue.Len() != c.Queued {
+			t.Errorf("Test case %d failed. Got %d queued", nr+1, handler.queue.queue.Len())
+		}
+		if c.Compared != "" && (len(compared) != 1 || compared[0] != c.Compared) || c.Compared == "" && len(compared) > 0 {
+			t.Errorf("Test case %d failed. Compared %v", nr+1, compared)
+		}
+	}
+}
+
+func TestGithubRelease(t *testing.T) {
+	withResourceCache(t, Pipeline{
+		ID:   1,
+		Name: "pipeline",
+		Team: "main",
+		Resources: []atc.ResourceConfig{
+			{Name: "releases", Type: "github-release", WebhookToken: "t", Source: atc.Source{"owner": "some", "repository": "repo"}},
+			{Name: "drafts", Type: "github-release", WebhookToken: "t", Source: atc.Source{"owner": "some", "repository": "repo", "drafts": true}},
+		},
+	})
+	repository := `"repository":{"full_name":"some/repo","clone_url":"https://github.com/some/repo.git"}`
+
+	cases := []struct {
+		body   string
+		Status int
+		Queued int
+	}{
+		{`{"action":"created","release":{"tag_name":"v1.0.0","draft":true},` + repository + `}`, 200, 1},
+		{`{"action":"created","release":{"tag_name":"v1.0.0","draft":false},` + repository + `}`, 202, 0},
+		{`{"action":"edited","release":{"tag_name":"v1.0.0","draft":true},` + repository + `}`, 200, 1},
+		{`{"action":"published","release":{"tag_name":"v1.0.0"},` + repository + `}`, 200, 1},
+	}
+	for nr, c := range cases {
+		handler := &GithubWebhookHandler{queue: NewRequestWorkqueue(1)}
+		req := httptest.NewRequest("POST", "/github", strings.NewReader(c.body))
+		req.Header.Set("X-GitHub-Event", "release")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Code != c.Status || handler.queue.queue.Len() != c.Queued {
+			t.Errorf("Test case %d failed. Got %d, %d queued", nr+1, rw.Code, handler.queue.queue.Len())
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+type GitlabWebhookHandler struct {
+	queue   *RequestWorkqueue
+	secrets *SecretStore
+}
+
+type gitlabPushEvent struct {
+	ObjectKind string `json:"object_kind"`
+	EventName  string `json:"event_name"`
+	Ref        string `json:"ref"`
+	Before     string `json:"before"`
+	After      string `json:"after"`
+	//TotalCommitsCount is the number of pushed commits, gitlab sends at most 20 of them
+	TotalCommitsCount int `json:"total_commits_count"`
+	Project           struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		GitHTTPURL        string `json:"git_http_url"`
+		GitSSHURL         string `json:"git_ssh_url"`
+		DefaultBranch     string `json:"default_branch"`
+	} `json:"project"`
+	Commits []struct {
+		ID            string   `json:"id"`
+		Message       string   `json:"message"`
+		AddedFiles    []string `json:"added"`
+		RemovedFiles  []string `json:"removed"`
+		ModifiedFiles []string `json:"modified"`
+	} `json:"commits"`
+}
+
+func (gl *GitlabWebhookHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	body, err := readBody(rw, req)
+	if err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to read request body: %s", err)
+		return
+	}
+	//the project selects the scoped tokens, a malformed body is only reported once the request is authenticated
+	var event gitlabPushEvent
+	parseErr := json.Unmarshal(body, &event)
+
+	if !gl.secrets.Empty() {
+		host, repository, _ := GitRepositoryIdentity(event.Project.GitHTTPURL)
+		token := req.Header.Get("X-Gitlab-Token")
+		if token == "" {
+			signatureRejections.WithLabelValues("gitlab", "missing").Inc()
+			http.Error(rw, "Missing X-Gitlab-Token header", http.StatusUnauthorized)
+			log.Printf("Rejecting gitlab webhook without token for %s", event.Project.GitHTTPURL)
+			return
+		}
+		if !verifyToken(token, gl.secrets.Secrets(host, repository)) {
+			signatureRejections.WithLabelValues("gitlab", "invalid").Inc()
+			http.Error(rw, "Invalid token", http.StatusUnauthorized)
+			log.Printf("Rejecting gitlab webhook with invalid token for %s", event.Project.GitHTTPURL)
+			return
+		}
+	}
+	if parseErr != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to parse request body: %s", parseErr)
+		return
+	}
+
+	//system hooks deliver the same payload as project hooks, the kind of event is in event_name
+	kind := event.ObjectKind
+	if req.Header.Get("X-Gitlab-Event") == "System Hook" {
+		kind = event.EventName
+	}
+
+	switch kind {
+	case "push", "tag_push":
+		gl.handlePush(rw, event)
+	default:
+		log.Printf("Ignoring unhandled gitlab event %s", kind)
+		rw.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(rw, "ignored: event %s is not handled\n", kind)
+	}
+}
+
+func (gl *GitlabWebhookHandler) handlePush(rw http.ResponseWriter, event gitlabPushEvent) {
+	for _, push := range event.pushEvents() {
+		BroadcastPush(gl.queue, push)
+	}
+}
+
+// parseGitlabPush returns the push event of a gitlab push or tag push payload, deletions are skipped
+func parseGitlabPush(payload []byte) ([]PushEvent, error) {
+	var event gitlabPushEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+	return event.pushEvents(), nil
+}
+
+func (event gitlabPushEvent) pushEvents() []PushEvent {
+	if event.After == zeroSHA {
+		log.Printf("Skipping deletion event for ref %s in %s", event.Ref, event.Project.GitHTTPURL)
+		return nil
+	}
+	log.Printf("Received gitlab webhook for %s, ref %s", event.Project.GitHTTPURL, event.Ref)
+
+	push := PushEvent{
+		Provider:       "gitlab",
+		RepositoryURLs: []string{event.Project.GitHTTPURL, event.Project.GitSSHURL},
+		Ref:            event.Ref,
+		After:          event.After,
+		DefaultBranch:  event.Project.DefaultBranch,
+	}
+	//collect list of commits and changed files
+	for _, commit := range event.Commits {
+		files := append(append(append([]string{}, commit.AddedFiles...), commit.RemovedFiles...), commit.ModifiedFiles...)
+		push.Commits = append(push.Commits, PushCommit{ID: commit.ID, Message: commit.Message, Files: files})
+		push.FilesChanged = append(push.FilesChanged, files...)
+	}
+	push.Incomplete = incompletePushReason(push, event.Before == zeroSHA, false, event.TotalCommitsCount, 0, 0)
+	return []PushEvent{push}
+}
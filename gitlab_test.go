@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/concourse/concourse/atc"
+)
+
+func TestGitlabWebhookHandler(t *testing.T) {
+	withResourceCache(t, Pipeline{
+		ID:   1,
+		Name: "pipeline",
+		Team: "main",
+		Resources: []atc.ResourceConfig{
+			{Name: "repo", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "git@gitlab.foo:group/repo.git"}},
+		},
+	})
+	push := `{"object_kind":"push","event_name":"push","ref":"refs/heads/main","after":"da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+		"project":{"git_http_url":"https://gitlab.foo/group/repo.git","git_ssh_url":"git@gitlab.foo:group/repo.git","default_branch":"main"},
+		"commits":[{"id":"da1560886d4f094c3e6c9ef40349f7d38b5d27d7","added":["README.md"]}]}`
+	systemEvent := `{"event_name":"project_create","project":{}}`
+
+	cases := []struct {
+		event  string
+		token  string
+		body   string
+		Status int
+		Queued int
+	}{
+		{"Push Hook", "s3cr3t", push, 200, 1},
+		{"System Hook", "s3cr3t", push, 200, 1},
+		{"System Hook", "s3cr3t", systemEvent, 202, 0},
+		{"Push Hook", "", push, 401, 0},
+		{"Push Hook", "wrong", push, 401, 0},
+		{"Push Hook", "", `not json`, 401, 0},
+		{"Push Hook", "s3cr3t", `not json`, 400, 0},
+	}
+	secrets, _ := NewSecretStore([]string{"s3cr3t"}, nil)
+	for nr, c := range cases {
+		handler := &GitlabWebhookHandler{NewRequestWorkqueue(1), secrets}
+		req := httptest.NewRequest("POST", "/gitlab", strings.NewReader(c.body))
+		req.Header.Set("X-Gitlab-Event", c.event)
+		if c.token != "" {
+			req.Header.Set("X-Gitlab-Token", c.token)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Code != c.Status || handler.queue.queue.Len() != c.Queued {
+			t.Errorf("Test case %d failed. Got %d, %d queued", nr+1, rw.Code, handler.queue.queue.Len())
+		}
+	}
+}
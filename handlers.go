@@ -1,140 +1,219 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"path/filepath"
+	"net/url"
+	"regexp"
 	"strings"
 
 	"github.com/concourse/concourse/atc"
 )
 
-type GithubWebhookHandler struct {
-	queue *RequestWorkqueue
+// maxPayloadSize is the maximum webhook payload size github delivers
+const maxPayloadSize = 25 << 20
+
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+// Policies for resources with a path filter when a push carries no list of changed files
+const (
+	PathsPolicyTrigger = "trigger"
+	PathsPolicySkip    = "skip"
+)
+
+func validPathsPolicy(policy string) bool {
+	return policy == PathsPolicyTrigger || policy == PathsPolicySkip
 }
 
-func (gh *GithubWebhookHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-
-	var pushEvent struct {
-		Ref        string `json:"ref"`
-		Before     string `json:"before"`
-		After      string `json:"after"`
-		CompareURL string `json:"compare"`
-		Repository struct {
-			FullName      string `json:"full_name"`
-			CloneURL      string `json:"clone_url"`
-			GitURL        string `json:"git_url"`
-			DefaultBranch string `json:"default_branch"`
-		} `json:"repository"`
-		Commits []struct {
-			ID            string   `json:"id"`
-			Message       string   `json:"message"`
-			AddedFiles    []string `json:"added"`
-			RemovedFiles  []string `json:"removed"`
-			ModifiedFiles []string `json:"modified"`
-		} `json:"commits"`
-	}
+// Policies for pushes whose commits don't cover all changes, e.g. new branches, force pushes or truncated payloads
+const (
+	//IncompletePushPolicyTrigger triggers resources regardless of their path and commit filters
+	IncompletePushPolicyTrigger = "trigger"
+	//IncompletePushPolicyCompare looks up the changed files via the api of the provider, falling back to trigger
+	IncompletePushPolicyCompare = "compare"
+)
+
+func validIncompletePushPolicy(policy string) bool {
+	return policy == IncompletePushPolicyTrigger || policy == IncompletePushPolicyCompare
+}
+
+// PushEvent is the provider independent representation of a push to a git repository
+type PushEvent struct {
+	Provider string
+	//RepositoryURLs contains all known clone urls (https, ssh, ...) of the repository
+	RepositoryURLs []string
+	Ref            string
+	//After is the pushed commit, empty if the provider doesn't send it
+	After string
+	//DefaultBranch is empty if the provider doesn't send it
+	DefaultBranch string
+	FilesChanged  []string
+	//Commits are the pushed commits, empty if the provider doesn't send them
+	Commits []PushCommit
+	//Incomplete is the reason why the commits and changed files don't cover all changes of the push, empty if they do
+	Incomplete string
+	//FilesUnknown is set if the provider doesn't send the list of changed files
+	FilesUnknown bool
+	//PathsPolicy decides about resources with a path filter if FilesUnknown is set
+	PathsPolicy string
+}
+
+// readBody reads the request body up to maxPayloadSize
+func readBody(rw http.ResponseWriter, req *http.Request) ([]byte, error) {
 	if req.Body == nil {
-		rw.WriteHeader(400)
-		log.Printf("Empty body")
-		return
+		return nil, fmt.Errorf("Empty body")
 	}
-	err := json.NewDecoder(req.Body).Decode(&pushEvent)
-	if err != nil {
-		rw.WriteHeader(400)
-		log.Printf("Failed to parse request body: %s", err)
-		return
+	return io.ReadAll(http.MaxBytesReader(rw, req.Body, maxPayloadSize))
+}
+
+// isGitResource returns true for resource types that track a git repository in source.uri
+func isGitResource(resource atc.ResourceConfig) bool {
+	return resource.Type == "git" || resource.Type == "pull-request" || resource.Type == "git-proxy"
+}
+
+// sameRepository returns true if uri references any of the given repository urls
+func sameRepository(uri string, repositoryURLs []string) bool {
+	if wildcardVar(uri) {
+		return true
 	}
+	for _, repositoryURL := range repositoryURLs {
+		if repositoryURL != "" && SameGitRepository(uri, repositoryURL) {
+			return true
+		}
+	}
+	return false
+}
 
-	if pushEvent.After == "0000000000000000000000000000000000000000" {
-		log.Printf("Skipping deletion event for ref %s in %s", pushEvent.Ref, pushEvent.Repository.CloneURL)
-		return
+// incompletePushReason returns why the commits of a push don't cover all its changes or an empty string.
+// totalCommits is the number of pushed commits if the provider sends it, maxCommits and maxFiles are
+// the limits at which the provider truncates the commits and changed files, 0 if unknown.
+func incompletePushReason(push PushEvent, created, forced bool, totalCommits, maxCommits, maxFiles int) string {
+	switch {
+	case created:
+		return "ref was created, the commits only contain changes not on other branches"
+	case forced:
+		return "push was forced, the commits don't contain the changes of removed commits"
+	case len(push.Commits) == 0:
+		return "push contains no commits"
+	case totalCommits > len(push.Commits):
+		return fmt.Sprintf("commits are truncated to %d of %d", len(push.Commits), totalCommits)
+	case maxCommits > 0 && len(push.Commits) >= maxCommits:
+		return fmt.Sprintf("commits are truncated to %d", len(push.Commits))
+	case maxFiles > 0 && len(push.FilesChanged) >= maxFiles:
+		return fmt.Sprintf("changed files are truncated to %d", len(push.FilesChanged))
 	}
-	log.Printf("Received webhhook for %s, ref %s, %s", pushEvent.Repository.CloneURL, pushEvent.Ref, pushEvent.CompareURL)
-
-	//collect list of changed files
-	filesChanged := []string{}
-	for _, commit := range pushEvent.Commits {
-		filesChanged = append(filesChanged, commit.AddedFiles...)
-		filesChanged = append(filesChanged, commit.RemovedFiles...)
-		filesChanged = append(filesChanged, commit.ModifiedFiles...)
+	return ""
+}
+
+// countRepositoryResources returns the number of cached resources referencing the given repository
+func countRepositoryResources(repositoryURL string) int {
+	return len(loadRepositoryIndex().Lookup([]string{repositoryURL}))
+}
+
+// BroadcastPush queues the webhooks of all cached resources tracking the pushed repository and branch.
+// It returns the number of resources notified.
+func BroadcastPush(queue *RequestWorkqueue, push PushEvent) int {
+	if push.Incomplete != "" {
+		log.Printf("Push of %s to %s is incomplete: %s", push.After, push.Ref, push.Incomplete)
 	}
+	notified := 0
+	for _, entry := range loadRepositoryIndex().Lookup(push.RepositoryURLs) {
+		pipeline, resource := entry.pipeline, entry.resource
+		//pull request resources are triggered by pull request events
+		if isPullRequestResource(resource) {
+			continue
+		}
+		//skip, if push is for a branch or tag not tracked by resource
+		if reason := skipRef(resource, push); reason != "" {
+			log.Printf("Skipping resource %s/%s in team %s. %s", pipeline.Ref(), resource.Name, pipeline.Team, reason)
+			continue
+		}
 
-	ScanResourceCache(func(pipeline Pipeline, resource atc.ResourceConfig) bool {
-		if resource.Type != "git" && resource.Type != "pull-request" && resource.Type != "git-proxy" {
-			return true
+		if entry.paths != nil && push.FilesUnknown {
+			if push.PathsPolicy == PathsPolicySkip {
+				log.Printf("Skipping resource %s/%s in team %s, changed files are unknown", pipeline.Ref(), resource.Name, pipeline.Team)
+				continue
+			}
+			debugf("resource %s/%s has path filter but changed files are unknown, triggering", pipeline.Ref(), resource.Name)
 		}
-		if uri, ok := resource.Source["uri"].(string); ok {
-			if SameGitRepository(uri, pushEvent.Repository.CloneURL) {
-				if resource.Type == "git" || resource.Type == "git-proxy" {
-					//skip, if push is for branch not tracked by resource
-					branch, _ := resource.Source["branch"].(string)
-					if branch == "" {
-						branch = pushEvent.Repository.DefaultBranch
-					}
-					if strings.TrimPrefix(pushEvent.Ref, "refs/heads/") != branch {
-						log.Printf("Skipping resource %s/%s in team %s. Which is tracking branch %s", pipeline.Name, resource.Name, pipeline.Team, branch)
-						return true
-					}
-				}
-
-				//skip if path filter of resource does not match any of the changed files
-				if ps, ok := resource.Source["paths"].([]interface{}); ok && len(ps) > 0 {
-					paths := make([]string, 0, len(ps))
-					for _, p := range ps {
-						if pstring, ok := p.(string); ok {
-							paths = append(paths, pstring)
-						}
-					}
-					if len(paths) > 0 && !matchFiles(paths, filesChanged) {
-						log.Printf("Skipping resource %s/%s in team %s, due to path filter", pipeline.Name, resource.Name, pipeline.Team)
-						return true
-					}
-					debugf("resource %s/%s has matching path filter: %#v", pipeline.Name, resource.Name, resource.Source)
-				} else {
-					debugf("resource %s/%s has no path filter: %#v", pipeline.Name, resource.Name, resource.Source)
-				}
-				webhookURL := fmt.Sprintf("%s/api/v1/teams/%s/pipelines/%s/resources/%s/check/webhook?webhook_token=%s",
-					concourseURL,
-					pipeline.Team,
-					pipeline.Name,
-					resource.Name,
-					resource.WebhookToken,
-				)
-				gh.queue.Add(webhookURL)
+		//skip if none of the commits passes the commit message and path filters of the resource,
+		//unless the commits of the push are incomplete
+		if push.Incomplete != "" {
+			if entry.paths != nil || entry.commits != nil {
+				log.Printf("Triggering resource %s/%s in team %s regardless of its filters, %s", pipeline.Ref(), resource.Name, pipeline.Team, push.Incomplete)
 			}
+		} else if reason := skipCommits(entry, push); reason != "" {
+			log.Printf("Skipping resource %s/%s in team %s, %s", pipeline.Ref(), resource.Name, pipeline.Team, reason)
+			continue
 		}
-		return true
-	})
+		queue.AddCheck(pipeline, resource, checkFromRef(entry, push))
+		notified++
+	}
+	return notified
+}
 
+// tracksTags returns true for git resources following tags instead of branch heads
+func tracksTags(resource atc.ResourceConfig) bool {
+	tagFilter, _ := resource.Source["tag_filter"].(string)
+	tagRegex, _ := resource.Source["tag_regex"].(string)
+	return tagFilter != "" || tagRegex != ""
 }
 
-func matchFiles(patterns []string, files []string) bool {
-	for _, file := range files {
-		for _, pattern := range patterns {
-			// direct match
-			if file == pattern {
-				debugf("direct match: %v == %v", file, pattern)
-				return true
+// skipRef returns the reason why a git resource is not affected by the pushed ref or an empty string.
+// Tag pushes are matched against tag_filter (glob) and tag_regex, branch resources only receive
+// tag pushes if they set fetch_tags.
+func skipRef(resource atc.ResourceConfig, push PushEvent) string {
+	if tag := strings.TrimPrefix(push.Ref, "refs/tags/"); tag != push.Ref {
+		if !tracksTags(resource) {
+			if fetchTags, _ := resource.Source["fetch_tags"].(bool); !fetchTags {
+				return "Which is not tracking tags"
 			}
-			// directory match
-			if strings.HasSuffix(pattern, "/") && strings.HasPrefix(file, pattern) {
-				debugf("directory match: %s matches %s", file, pattern)
-				return true
+			return ""
+		}
+		if tagFilter, _ := resource.Source["tag_filter"].(string); tagFilter != "" && !wildcardVar(tagFilter) {
+			if !matchGlob(tagFilter, tag) {
+				return fmt.Sprintf("Which is tracking tags matching %s", tagFilter)
 			}
-			// directory without trainling / match
-			if strings.HasPrefix(file, pattern+"/") {
-				debugf("prefix match: %s matches %s", file, pattern)
-				return true
+		}
+		if tagRegex, _ := resource.Source["tag_regex"].(string); tagRegex != "" && !wildcardVar(tagRegex) {
+			re, err := regexp.Compile(tagRegex)
+			if err != nil {
+				return fmt.Sprintf("Invalid tag_regex %s: %s", tagRegex, err)
 			}
-			//last resort glob match
-			if ok, _ := filepath.Match(pattern, file); ok {
-				debugf("glob match: %s matches %s", file, pattern)
-				return true
+			if !re.MatchString(tag) {
+				return fmt.Sprintf("Which is tracking tags matching %s", tagRegex)
 			}
 		}
+		return ""
 	}
-	return false
+
+	if tracksTags(resource) {
+		return "Which is tracking tags"
+	}
+	branch, _ := resource.Source["branch"].(string)
+	if branch == "" {
+		branch = push.DefaultBranch
+	}
+	//without a known default branch we can't tell if an unqualified resource is affected
+	if branch != "" && !wildcardVar(branch) && strings.TrimPrefix(push.Ref, "refs/heads/") != branch {
+		return "Which is tracking branch " + branch
+	}
+	return ""
+}
+
+// webhookURL returns the check webhook url of a resource, the instance vars of instanced pipelines are passed as vars.* query parameters
+func webhookURL(pipeline Pipeline, resource atc.ResourceConfig) string {
+	query := url.Values{"webhook_token": []string{resource.WebhookToken}}
+	for key, values := range pipeline.Ref().QueryParams() {
+		query[key] = values
+	}
+	return fmt.Sprintf("%s/api/v1/teams/%s/pipelines/%s/resources/%s/check/webhook?%s",
+		concourseURL,
+		pipeline.Team,
+		pipeline.Name,
+		resource.Name,
+		query.Encode(),
+	)
 }
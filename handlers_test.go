@@ -1,6 +1,11 @@
 package main
 
-import "testing"
+import (
+	"fmt"
+	"testing"
+
+	"github.com/concourse/concourse/atc"
+)
 
 func TestMatchFiles(t *testing.T) {
 	cases := []struct {
@@ -11,10 +16,214 @@ func TestMatchFiles(t *testing.T) {
 		{[]string{"ap-ae-1/values/globals.yaml"}, []string{"qa-de-1/values/designate.yaml"}, false},
 	}
 	for nr, c := range cases {
-		if matchFiles(c.patterns, c.files) != c.Result {
+		if newPathFilter(c.patterns, nil).Match(c.files) != c.Result {
 			t.Errorf("Test case %d failed.", nr+1)
 		}
 
 	}
 
 }
+
+// withResourceCache replaces the resource cache with the given pipelines for the duration of a test
+func withResourceCache(t testing.TB, pipelines ...Pipeline) {
+	resourceCache.Range(func(key, _ interface{}) bool {
+		resourceCache.Delete(key)
+		return true
+	})
+	for _, pipeline := range pipelines {
+		resourceCache.Store(pipeline.ID, pipeline)
+	}
+	rebuildRepositoryIndex()
+	t.Cleanup(func() {
+		for _, pipeline := range pipelines {
+			resourceCache.Delete(pipeline.ID)
+		}
+		rebuildRepositoryIndex()
+	})
+}
+
+func TestBroadcastPush(t *testing.T) {
+	withResourceCache(t, Pipeline{
+		ID:   1,
+		Name: "pipeline",
+		Team: "main",
+		Resources: []atc.ResourceConfig{
+			{Name: "default-branch", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/some/repo.git"}},
+			{Name: "feature-branch", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "git@git.foo:some/repo.git", "branch": "feature"}},
+			{Name: "charts", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/some/repo", "paths": []interface{}{"charts/"}}},
+			{Name: "code", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/some/repo", "ignore_paths": []interface{}{"docs/", "*.md"}}},
+			{Name: "other-repo", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/other/repo"}},
+			{Name: "releases", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/some/repo.git", "tag_filter": "v*"}},
+			{Name: "pull-requests", Type: "pull-request", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/some/repo.git"}},
+			{Name: "image", Type: "registry-image", WebhookToken: "t", Source: atc.Source{"repository": "some/repo"}},
+		},
+	})
+
+	cases := []struct {
+		push   PushEvent
+		Result int
+	}{
+		{PushEvent{RepositoryURLs: []string{"https://git.foo/some/repo.git"}, Ref: "refs/heads/master", DefaultBranch: "master", FilesChanged: []string{"README.md"}}, 1},
+		{PushEvent{RepositoryURLs: []string{"https://git.foo/some/repo.git"}, Ref: "refs/heads/master", DefaultBranch: "master", FilesChanged: []string{"charts/values.yaml"}}, 3},
+		{PushEvent{RepositoryURLs: []string{"https://git.foo/some/repo.git"}, Ref: "refs/heads/master", DefaultBranch: "master", FilesChanged: []string{"docs/index.md", "docs/img/logo.png"}}, 1},
+		{PushEvent{RepositoryURLs: []string{"https://git.foo/some/repo.git"}, Ref: "refs/heads/master", DefaultBranch: "master", FilesChanged: []string{"docs/index.md", "cmd/main.go"}}, 2},
+		{PushEvent{RepositoryURLs: []string{"", "ssh://git@git.foo/some/repo.git"}, Ref: "refs/heads/feature", DefaultBranch: "master"}, 1},
+		{PushEvent{RepositoryURLs: []string{"https://git.foo/unknown/repo.git"}, Ref: "refs/heads/master", DefaultBranch: "master"}, 0},
+		{PushEvent{RepositoryURLs: []string{"https://git.foo/some/repo.git"}, Ref: "refs/tags/v1.0.0", DefaultBranch: "master"}, 1},
+		{PushEvent{RepositoryURLs: []string{"https://git.foo/some/repo.git"}, Ref: "refs/tags/nightly", DefaultBranch: "master"}, 0},
+	}
+	queue := NewRequestWorkqueue(1)
+	for nr, c := range cases {
+		if result := BroadcastPush(queue, c.push); result != c.Result {
+			t.Errorf("Test case %d failed. Got %d", nr+1, result)
+		}
+	}
+}
+
+func TestBroadcastPushCheckRef(t *testing.T) {
+	withResourceCache(t, Pipeline{
+		ID:   1,
+		Name: "pipeline",
+		Team: "main",
+		Resources: []atc.ResourceConfig{
+			{Name: "branch", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/some/repo.git", "disable_ci_skip": true}},
+			{Name: "ci-skip", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/some/repo.git"}},
+			{Name: "charts", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/some/repo.git", "paths": []interface{}{"charts/"}}},
+			{Name: "releases", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/some/repo.git", "tag_filter": "v*"}},
+		},
+	})
+	push := func(ref string, commits ...PushCommit) PushEvent {
+		return PushEvent{RepositoryURLs: []string{"https://git.foo/some/repo.git"}, Ref: ref, After: commits[len(commits)-1].ID, DefaultBranch: "master", Commits: commits}
+	}
+
+	cases := []struct {
+		push PushEvent
+		Refs map[string]string
+	}{
+		{push("refs/heads/master", PushCommit{ID: "a", Message: "Bump chart", Files: []string{"charts/values.yaml"}}),
+			map[string]string{"branch": "a", "ci-skip": "a", "charts": "a"}},
+		//the head doesn't pass the path filter, checking from it would miss the first commit
+		{push("refs/heads/master", PushCommit{ID: "a", Message: "Bump chart", Files: []string{"charts/values.yaml"}}, PushCommit{ID: "b", Message: "Fix typo", Files: []string{"README.md"}}),
+			map[string]string{"branch": "b", "ci-skip": "b", "charts": ""}},
+		{push("refs/heads/master", PushCommit{ID: "a", Message: "Fix bug", Files: []string{"main.go"}}, PushCommit{ID: "b", Message: "Update docs [ci skip]", Files: []string{"charts/README.md"}}),
+			map[string]string{"branch": "b", "ci-skip": ""}},
+		//annotated tags and tag versions can't be checked from the pushed object
+		{push("refs/tags/v1.0.0", PushCommit{ID: "tag-object", Message: "Release"}),
+			map[string]string{"releases": ""}},
+		{PushEvent{RepositoryURLs: []string{"https://git.foo/some/repo.git"}, Ref: "refs/heads/master", After: "c", DefaultBranch: "master", FilesUnknown: true},
+			map[string]string{"branch": "c", "ci-skip": "", "charts": ""}},
+	}
+	for nr, c := range cases {
+		queue := NewRequestWorkqueue(1)
+		queue.checkFromRef = true
+		BroadcastPush(queue, c.push)
+		refs := map[string]string{}
+		for queue.queue.Len() > 0 {
+			item, _ := queue.queue.Get()
+			request := item.(checkRequest)
+			refs[request.Resource] = request.Ref
+			queue.queue.Done(item)
+		}
+		if len(refs) != len(c.Refs) {
+			t.Errorf("Test case %d failed. Got %v", nr+1, refs)
+			continue
+		}
+		for resource, ref := range c.Refs {
+			if got, ok := refs[resource]; !ok || got != ref {
+				t.Errorf("Test case %d failed. Got %v", nr+1, refs)
+			}
+		}
+	}
+}
+
+func TestSkipRef(t *testing.T) {
+	cases := []struct {
+		source atc.Source
+		ref    string
+		Skip   bool
+	}{
+		{atc.Source{}, "refs/heads/master", false},
+		{atc.Source{"branch": "master"}, "refs/heads/master", false},
+		{atc.Source{"branch": "feature"}, "refs/heads/master", true},
+		{atc.Source{"branch": "master"}, "refs/tags/v1.0.0", true},
+		{atc.Source{}, "refs/tags/v1.0.0", true},
+		{atc.Source{"branch": "master", "fetch_tags": true}, "refs/tags/v1.0.0", false},
+		{atc.Source{"tag_filter": "v*"}, "refs/tags/v1.0.0", false},
+		{atc.Source{"tag_filter": "v*"}, "refs/tags/release-1.0", true},
+		{atc.Source{"tag_filter": "v*"}, "refs/heads/master", true},
+		{atc.Source{"tag_filter": "release/*"}, "refs/tags/release/v1/rc", false},
+		{atc.Source{"tag_filter": "*"}, "refs/tags/team/v1.0.0", false},
+		{atc.Source{"tag_filter": "release/v?"}, "refs/tags/release/v1", false},
+		{atc.Source{"tag_filter": "release/*"}, "refs/tags/hotfix/v1", true},
+		{atc.Source{"tag_filter": "v1"}, "refs/tags/v1/rc", true},
+		{atc.Source{"tag_regex": "^v[0-9]+\\.[0-9]+\\.[0-9]+$"}, "refs/tags/v1.0.0", false},
+		{atc.Source{"tag_regex": "^v[0-9]+\\.[0-9]+\\.[0-9]+$"}, "refs/tags/v1.0.0-rc1", true},
+		{atc.Source{"tag_regex": "("}, "refs/tags/v1.0.0", true},
+		{atc.Source{"tag_filter": "v*", "tag_regex": "-rc"}, "refs/tags/v1.0.0", true},
+		{atc.Source{"tag_regex": "^v", "branch": "master"}, "refs/heads/master", true},
+	}
+	for nr, c := range cases {
+		reason := skipRef(atc.ResourceConfig{Source: c.source}, PushEvent{Ref: c.ref, DefaultBranch: "master"})
+		if (reason != "") != c.Skip {
+			t.Errorf("Test case %d failed. Got %q", nr+1, reason)
+		}
+	}
+}
+
+func TestWebhookURL(t *testing.T) {
+	defer func(url string) { concourseURL = url }(concourseURL)
+	concourseURL = "https://ci.foo"
+	resource := atc.ResourceConfig{Name: "repo", WebhookToken: "t"}
+
+	cases := []struct {
+		pipeline Pipeline
+		Result   string
+	}{
+		{Pipeline{Name: "deploy", Team: "main"}, "https://ci.foo/api/v1/teams/main/pipelines/deploy/resources/repo/check/webhook?webhook_token=t"},
+		{
+			Pipeline{Name: "deploy", Team: "main", InstanceVars: atc.InstanceVars{"region": "eu-de-1", "stage": map[string]interface{}{"name": "qa"}}},
+			"https://ci.foo/api/v1/teams/main/pipelines/deploy/resources/repo/check/webhook?vars.region=%22eu-de-1%22&vars.stage.name=%22qa%22&webhook_token=t",
+		},
+	}
+	for nr, c := range cases {
+		if result := webhookURL(c.pipeline, resource); result != c.Result {
+			t.Errorf("Test case %d failed. Got %s", nr+1, result)
+		}
+	}
+}
+
+func TestIncompletePushReason(t *testing.T) {
+	commits := func(n int) PushEvent {
+		push := PushEvent{}
+		for i := 0; i < n; i++ {
+			push.Commits = append(push.Commits, PushCommit{ID: fmt.Sprint(i), Files: []string{"a", "b"}})
+			push.FilesChanged = append(push.FilesChanged, "a", "b")
+		}
+		return push
+	}
+	cases := []struct {
+		push         PushEvent
+		created      bool
+		forced       bool
+		totalCommits int
+		maxCommits   int
+		maxFiles     int
+		Incomplete   bool
+	}{
+		{commits(1), false, false, 0, 0, 0, false},
+		{commits(1), true, false, 0, 0, 0, true},
+		{commits(1), false, true, 0, 0, 0, true},
+		{commits(0), false, false, 0, 0, 0, true},
+		{commits(3), false, false, 3, 0, 0, false},
+		{commits(3), false, false, 25, 0, 0, true},
+		{commits(19), false, false, 0, 20, 0, false},
+		{commits(20), false, false, 0, 20, 0, true},
+		{commits(3), false, false, 0, 20, 6, true},
+		{commits(2), false, false, 0, 20, 6, false},
+	}
+	for nr, c := range cases {
+		if reason := incompletePushReason(c.push, c.created, c.forced, c.totalCommits, c.maxCommits, c.maxFiles); (reason != "") != c.Incomplete {
+			t.Errorf("Test case %d failed. Got %q", nr+1, reason)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// HarborWebhookHandler handles PUSH_ARTIFACT webhooks of harbor
+type HarborWebhookHandler struct {
+	queue *RequestWorkqueue
+	//secrets are the accepted values of the Authorization header ("Auth Header" of the webhook policy)
+	secrets []string
+}
+
+type harborEvent struct {
+	Type      string `json:"type"`
+	EventData struct {
+		Resources []struct {
+			Digest      string `json:"digest"`
+			Tag         string `json:"tag"`
+			ResourceURL string `json:"resource_url"`
+		} `json:"resources"`
+		Repository struct {
+			RepoFullName string `json:"repo_full_name"`
+		} `json:"repository"`
+	} `json:"event_data"`
+}
+
+func (hb *HarborWebhookHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if reason := verifyAuthorizationHeader(req, hb.secrets); reason != "" {
+		signatureRejections.WithLabelValues("harbor", reason).Inc()
+		http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+		log.Printf("Rejecting harbor webhook: %s auth header", reason)
+		return
+	}
+
+	body, err := readBody(rw, req)
+	if err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to read request body: %s", err)
+		return
+	}
+	var event harborEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to parse request body: %s", err)
+		return
+	}
+	if event.Type != "PUSH_ARTIFACT" && event.Type != "pushImage" {
+		log.Printf("Ignoring unhandled harbor event %s for %s", event.Type, event.EventData.Repository.RepoFullName)
+		rw.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(rw, "ignored: event %s is not handled\n", event.Type)
+		return
+	}
+
+	notified := 0
+	for _, push := range event.pushEvents() {
+		notified += BroadcastImagePush(hb.queue, push)
+	}
+	fmt.Fprintf(rw, "ok: %d resource(s) notified\n", notified)
+}
+
+func (event harborEvent) pushEvents() []ImagePushEvent {
+	pushes := make([]ImagePushEvent, 0, len(event.EventData.Resources))
+	for _, resource := range event.EventData.Resources {
+		//resource_url is host/project/repo:tag or host/project/repo@digest
+		host := strings.SplitN(resource.ResourceURL, "/", 2)[0]
+		if host == resource.ResourceURL {
+			host = ""
+		}
+		pushes = append(pushes, ImagePushEvent{
+			Provider:   "harbor",
+			Host:       host,
+			Repository: event.EventData.Repository.RepoFullName,
+			Tag:        resource.Tag,
+		})
+	}
+	return pushes
+}
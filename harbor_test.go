@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/concourse/concourse/atc"
+)
+
+func TestHarborWebhookHandler(t *testing.T) {
+	withResourceCache(t, Pipeline{
+		ID:   1,
+		Name: "pipeline",
+		Team: "main",
+		Resources: []atc.ResourceConfig{
+			{Name: "nginx", Type: "registry-image", WebhookToken: "t", Source: atc.Source{"repository": "harbor.example.com/library/nginx", "tag": "1.21"}},
+		},
+	})
+	body := `{"type":"PUSH_ARTIFACT","occur_at":1680000000,"operator":"admin","event_data":{"resources":[{"digest":"sha256:abc","tag":"1.21","resource_url":"harbor.example.com/library/nginx:1.21"}],"repository":{"name":"nginx","namespace":"library","repo_full_name":"library/nginx","repo_type":"private"}}}`
+
+	cases := []struct {
+		authorization string
+		body          string
+		Status        int
+		Queued        int
+	}{
+		{"s3cr3t", body, 200, 1},
+		{"Bearer s3cr3t", body, 200, 1},
+		{"wrong", body, 401, 0},
+		{"", body, 401, 0},
+		{"s3cr3t", strings.Replace(body, "PUSH_ARTIFACT", "DELETE_ARTIFACT", 1), 202, 0},
+		{"s3cr3t", `not json`, 400, 0},
+	}
+	for nr, c := range cases {
+		handler := &HarborWebhookHandler{NewRequestWorkqueue(1), []string{"s3cr3t"}}
+		req := httptest.NewRequest("POST", "/harbor", strings.NewReader(c.body))
+		if c.authorization != "" {
+			req.Header.Set("Authorization", c.authorization)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Code != c.Status || handler.queue.queue.Len() != c.Queued {
+			t.Errorf("Test case %d failed. Got %d, %d queued", nr+1, rw.Code, handler.queue.queue.Len())
+		}
+	}
+}
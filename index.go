@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/concourse/concourse/atc"
+)
+
+// indexedResource is a cached git resource with its precompiled filters
+type indexedResource struct {
+	pipeline Pipeline
+	resource atc.ResourceConfig
+	//paths is the path filter of the resource, nil if it has none
+	paths *pathFilter
+	//commits is the commit message filter of the resource, nil if it doesn't filter commits
+	commits *commitFilter
+}
+
+// repositoryIndex maps normalized repository identities to the git resources referencing them
+type repositoryIndex struct {
+	resources map[string][]*indexedResource
+	//unindexed are resources whose uri can't be normalized or is a wildcard, they are compared one by one
+	unindexed []*indexedResource
+}
+
+var currentRepositoryIndex atomic.Value
+
+func repositoryKey(host, repository string) string {
+	return host + "/" + repository
+}
+
+// buildRepositoryIndex builds the index of all git resources in the resource cache
+func buildRepositoryIndex() *repositoryIndex {
+	index := &repositoryIndex{resources: map[string][]*indexedResource{}}
+	ScanResourceCache(func(pipeline Pipeline, resource atc.ResourceConfig) bool {
+		if !isGitResource(resource) {
+			return true
+		}
+		uri, ok := resource.Source["uri"].(string)
+		if !ok {
+			return true
+		}
+		entry := &indexedResource{pipeline: pipeline, resource: resource, paths: gitPathFilter(resource.Source), commits: newCommitFilter(resource.Source)}
+		host, repository, ok := GitRepositoryIdentity(uri)
+		if !ok || wildcardVar(uri) {
+			index.unindexed = append(index.unindexed, entry)
+			return true
+		}
+		key := repositoryKey(host, repository)
+		index.resources[key] = append(index.resources[key], entry)
+		return true
+	})
+	return index
+}
+
+// rebuildRepositoryIndex replaces the index with one built from the current resource cache
+func rebuildRepositoryIndex() {
+	currentRepositoryIndex.Store(buildRepositoryIndex())
+}
+
+// loadRepositoryIndex returns the current index
+func loadRepositoryIndex() *repositoryIndex {
+	if index, ok := currentRepositoryIndex.Load().(*repositoryIndex); ok {
+		return index
+	}
+	return &repositoryIndex{}
+}
+
+// Lookup returns the resources referencing any of the repository urls
+func (index *repositoryIndex) Lookup(repositoryURLs []string) []*indexedResource {
+	var result []*indexedResource
+	seen := map[string]bool{}
+	for _, repositoryURL := range repositoryURLs {
+		host, repository, ok := GitRepositoryIdentity(repositoryURL)
+		if !ok {
+			continue
+		}
+		key := repositoryKey(host, repository)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, index.resources[key]...)
+	}
+	for _, entry := range index.unindexed {
+		if sameRepository(entry.resource.Source["uri"].(string), repositoryURLs) {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
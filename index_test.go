@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/concourse/concourse/atc"
+)
+
+func TestRepositoryIndexLookup(t *testing.T) {
+	defer func(policy string) { unresolvedVarsPolicy = policy }(unresolvedVarsPolicy)
+	unresolvedVarsPolicy = VarsPolicyWildcard
+	withResourceCache(t,
+		Pipeline{
+			ID:   1,
+			Name: "pipeline",
+			Team: "main",
+			Resources: []atc.ResourceConfig{
+				{Name: "https", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/some/repo.git", "paths": []interface{}{"charts/", 1}}},
+				{Name: "ssh", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "git@git.foo:some/repo"}},
+				{Name: "templated", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "((repo_uri))"}},
+				{Name: "other", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/other/repo.git"}},
+				{Name: "image", Type: "registry-image", WebhookToken: "t", Source: atc.Source{"repository": "some/repo"}},
+			},
+		},
+		Pipeline{
+			ID:   2,
+			Name: "other-pipeline",
+			Team: "other",
+			Resources: []atc.ResourceConfig{
+				{Name: "prs", Type: "pull-request", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/some/repo"}},
+			},
+		},
+	)
+
+	cases := []struct {
+		urls   []string
+		Result []string
+	}{
+		{[]string{"https://git.foo/some/repo.git", "git@git.foo:some/repo.git"}, []string{"https", "ssh", "prs", "templated"}},
+		{[]string{"https://git.foo/other/repo.git"}, []string{"other", "templated"}},
+		{[]string{"https://git.foo/unknown/repo.git", ""}, []string{"templated"}},
+	}
+	for nr, c := range cases {
+		entries := loadRepositoryIndex().Lookup(c.urls)
+		names := map[string]bool{}
+		for _, entry := range entries {
+			names[entry.resource.Name] = true
+		}
+		if len(entries) != len(c.Result) || len(names) != len(c.Result) {
+			t.Errorf("Test case %d failed. Got %v", nr+1, names)
+			continue
+		}
+		for _, name := range c.Result {
+			if !names[name] {
+				t.Errorf("Test case %d failed. Got %v", nr+1, names)
+			}
+		}
+	}
+
+	for _, entry := range loadRepositoryIndex().Lookup([]string{"https://git.foo/some/repo"}) {
+		if (entry.paths != nil) != (entry.resource.Name == "https") {
+			t.Errorf("Unexpected path filter %v for %s", entry.paths, entry.resource.Name)
+		}
+	}
+}
+
+// benchmarkPipelines returns pipelines with the given number of git resources spread over 1000 repositories
+func benchmarkPipelines(resources int) []Pipeline {
+	var pipelines []Pipeline
+	for i := 0; i < resources; i += 10 {
+		pipeline := Pipeline{ID: i, Name: fmt.Sprintf("pipeline-%d", i), Team: "main"}
+		for j := i; j < i+10 && j < resources; j++ {
+			pipeline.Resources = append(pipeline.Resources, atc.ResourceConfig{
+				Name:         fmt.Sprintf("resource-%d", j),
+				Type:         "git",
+				WebhookToken: "t",
+				Source:       atc.Source{"uri": fmt.Sprintf("git@git.foo:org/repo-%d.git", j%1000), "paths": []interface{}{"charts/"}},
+			})
+		}
+		pipelines = append(pipelines, pipeline)
+	}
+	return pipelines
+}
+
+func BenchmarkRepositoryIndexLookup(b *testing.B) {
+	urls := []string{"https://git.foo/org/repo-42.git", "git@git.foo:org/repo-42.git"}
+	for _, size := range []int{100, 1000, 10000, 50000} {
+		b.Run(fmt.Sprintf("resources=%d", size), func(b *testing.B) {
+			withResourceCache(b, benchmarkPipelines(size)...)
+			index := buildRepositoryIndex()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				index.Lookup(urls)
+			}
+		})
+	}
+}
+
+// BenchmarkScanResourceCache measures the lookup by scanning the whole cache for comparison
+func BenchmarkScanResourceCache(b *testing.B) {
+	urls := []string{"https://git.foo/org/repo-42.git", "git@git.foo:org/repo-42.git"}
+	for _, size := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("resources=%d", size), func(b *testing.B) {
+			withResourceCache(b, benchmarkPipelines(size)...)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ScanResourceCache(func(pipeline Pipeline, resource atc.ResourceConfig) bool {
+					sameRepository(resource.Source["uri"].(string), urls)
+					return true
+				})
+			}
+		})
+	}
+}
+
+func BenchmarkBuildRepositoryIndex(b *testing.B) {
+	for _, size := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("resources=%d", size), func(b *testing.B) {
+			withResourceCache(b, benchmarkPipelines(size)...)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				buildRepositoryIndex()
+			}
+		})
+	}
+}
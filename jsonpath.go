@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPathStep is a single step of a parsed json path expression
+type jsonPathStep struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parseJSONPath parses a simple json path expression like `$.repository.url`,
+// `commits[0].id` or `commits[*].added`
+func parseJSONPath(expr string) ([]jsonPathStep, error) {
+	expr = strings.TrimPrefix(strings.TrimPrefix(expr, "$"), ".")
+	if expr == "" {
+		return nil, fmt.Errorf("Empty json path")
+	}
+	var steps []jsonPathStep
+	for _, segment := range strings.Split(expr, ".") {
+		key := segment
+		var indexes []string
+		if idx := strings.Index(segment, "["); idx >= 0 {
+			key = segment[:idx]
+			rest := segment[idx:]
+			for rest != "" {
+				end := strings.Index(rest, "]")
+				if !strings.HasPrefix(rest, "[") || end < 0 {
+					return nil, fmt.Errorf("Invalid json path segment %s", segment)
+				}
+				indexes = append(indexes, rest[1:end])
+				rest = rest[end+1:]
+			}
+		}
+		if key == "" && len(indexes) == 0 {
+			return nil, fmt.Errorf("Empty segment in json path %s", expr)
+		}
+		if key == "*" {
+			steps = append(steps, jsonPathStep{wildcard: true})
+		} else if key != "" {
+			steps = append(steps, jsonPathStep{key: key})
+		}
+		for _, index := range indexes {
+			if index == "*" {
+				steps = append(steps, jsonPathStep{wildcard: true})
+				continue
+			}
+			i, err := strconv.Atoi(index)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid index %s in json path %s", index, expr)
+			}
+			steps = append(steps, jsonPathStep{index: i, isIndex: true})
+		}
+	}
+	return steps, nil
+}
+
+// evalJSONPath returns all values of the decoded json document matched by the steps.
+// Arrays reached by the last step are flattened.
+func evalJSONPath(steps []jsonPathStep, doc interface{}) []interface{} {
+	current := []interface{}{doc}
+	for _, step := range steps {
+		var next []interface{}
+		for _, value := range current {
+			switch v := value.(type) {
+			case map[string]interface{}:
+				if step.wildcard {
+					for _, child := range v {
+						next = append(next, child)
+					}
+				} else if child, ok := v[step.key]; ok && !step.isIndex {
+					next = append(next, child)
+				}
+			case []interface{}:
+				if step.wildcard {
+					next = append(next, v...)
+				} else if step.isIndex && step.index >= 0 && step.index < len(v) {
+					next = append(next, v[step.index])
+				}
+			}
+		}
+		current = next
+	}
+	var result []interface{}
+	for _, value := range current {
+		if list, ok := value.([]interface{}); ok {
+			result = append(result, list...)
+		} else if value != nil {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// jsonPathStrings returns all string values matched by the steps
+func jsonPathStrings(steps []jsonPathStep, doc interface{}) []string {
+	var result []string
+	for _, value := range evalJSONPath(steps, doc) {
+		if s, ok := value.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
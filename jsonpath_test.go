@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestJSONPath(t *testing.T) {
+	var doc interface{}
+	json.Unmarshal([]byte(`{"repo":{"url":"https://git.foo/some/repo"},"ref":"main","changes":[{"path":"a.txt"},{"path":"b/c.txt"}],"files":["x","y"]}`), &doc)
+
+	cases := []struct {
+		expr   string
+		Result []string
+	}{
+		{"$.repo.url", []string{"https://git.foo/some/repo"}},
+		{"repo.url", []string{"https://git.foo/some/repo"}},
+		{"ref", []string{"main"}},
+		{"changes[*].path", []string{"a.txt", "b/c.txt"}},
+		{"changes[1].path", []string{"b/c.txt"}},
+		{"changes[5].path", nil},
+		{"files", []string{"x", "y"}},
+		{"missing.key", nil},
+	}
+	for nr, c := range cases {
+		steps, err := parseJSONPath(c.expr)
+		if err != nil {
+			t.Errorf("Test case %d failed: %s", nr+1, err)
+			continue
+		}
+		if result := jsonPathStrings(steps, doc); !reflect.DeepEqual(result, c.Result) {
+			t.Errorf("Test case %d failed. Got %v", nr+1, result)
+		}
+	}
+
+	for _, invalid := range []string{"", "$", "a..b", "a[x]", "a[0"} {
+		if _, err := parseJSONPath(invalid); err == nil {
+			t.Errorf("Expected error for json path %q", invalid)
+		}
+	}
+}
@@ -23,14 +23,47 @@ type resource struct {
 }
 
 var (
-	listenAddr         string
-	concourseURL       string
-	authUser           string
-	authPassword       string
-	refreshInterval    time.Duration
-	webhookConcurrency int
-	flags              *flag.FlagSet
-	debug              bool
+	listenAddr                  string
+	concourseURL                string
+	authUser                    string
+	authPassword                string
+	refreshInterval             time.Duration
+	webhookConcurrency          int
+	flags                       *flag.FlagSet
+	debug                       bool
+	githubSecrets               stringSliceFlag
+	githubScopedSecrets         stringSliceFlag
+	gitlabTokens                stringSliceFlag
+	gitlabScopedTokens          stringSliceFlag
+	bitbucketServerSecrets      stringSliceFlag
+	bitbucketServerPathsPolicy  string
+	bitbucketServerURL          string
+	bitbucketCloudHookUUIDs     stringSliceFlag
+	bitbucketCloudSecrets       stringSliceFlag
+	bitbucketCloudScopedSecrets stringSliceFlag
+	bitbucketCloudPathsPolicy   string
+	giteaSecrets                stringSliceFlag
+	giteaScopedSecrets          stringSliceFlag
+	azureDevOpsUser             string
+	azureDevOpsPassword         string
+	azureDevOpsPathsPolicy      string
+	gerritURLs                  stringSliceFlag
+	gerritResourceTypes         stringSliceFlag
+	gerritSecrets               stringSliceFlag
+	configFile                  string
+	githubAPIToken              string
+	githubIncompletePushPolicy  string
+	dockerHubTokens             stringSliceFlag
+	harborSecrets               stringSliceFlag
+	distributionSecrets         stringSliceFlag
+	registryAliases             stringSliceFlag
+	s3Secrets                   stringSliceFlag
+	s3SNSTopics                 stringSliceFlag
+	checkFromPushedCommit       bool
+	tokenlessResources          bool
+	tokenlessTeams              stringSliceFlag
+	tokenlessExcludedTeams      stringSliceFlag
+	unresolvedVarsPolicy        string
 )
 
 func init() {
@@ -43,7 +76,39 @@ func init() {
 	flags.DurationVar(&refreshInterval, "refresh-interval", 5*time.Minute, "Resource refresh interval")
 	flags.IntVar(&webhookConcurrency, "webhook-concurrency", 20, "How many resources to notify in parallel")
 	flags.BoolVar(&debug, "dry-run", false, "Dry-run. Don't call webhooks")
-
+	flags.BoolVar(&checkFromPushedCommit, "check-from-pushed-commit", false, "Check git resources via the concourse api starting from the pushed commit. Falls back to the webhook if the api refuses the check")
+	flags.StringVar(&configFile, "config-file", "", "Optional yaml or json configuration file, e.g. for generic webhook mappings")
+	flags.Var(&githubSecrets, "github-secret", "Secret used to verify github webhook signatures. Can be given multiple times for rotation")
+	flags.Var(&githubScopedSecrets, "github-scoped-secret", "Secret for a single github host or repository in the form host[/org/repo]=secret. Can be given multiple times")
+	flags.StringVar(&githubIncompletePushPolicy, "github-incomplete-push-policy", IncompletePushPolicyTrigger, "How to treat resources with path or commit filters on new branch, forced or truncated github pushes, which carry incomplete changes: trigger or compare (via the github api, requires -github-api-token)")
+	flags.StringVar(&githubAPIToken, "github-api-token", "", "Optional github token used to look up the changed files of pull requests and incomplete pushes for path filters")
+	flags.Var(&gitlabTokens, "gitlab-token", "Secret token expected in the X-Gitlab-Token header. Can be given multiple times for rotation")
+	flags.Var(&gitlabScopedTokens, "gitlab-scoped-token", "Secret token for a single gitlab host or repository in the form host[/group/repo]=token. Can be given multiple times")
+	flags.Var(&bitbucketServerSecrets, "bitbucket-server-secret", "Secret used to verify bitbucket server webhook signatures. Can be given multiple times for rotation")
+	flags.StringVar(&bitbucketServerPathsPolicy, "bitbucket-server-paths-policy", PathsPolicyTrigger, "How to treat resources with a paths filter on bitbucket server pushes, which carry no changed files: trigger or skip")
+	flags.StringVar(&bitbucketServerURL, "bitbucket-server-url", "", "Optional base url of bitbucket server, used to build the clone urls of webhooks without clone links (older bitbucket server versions)")
+	flags.Var(&bitbucketCloudHookUUIDs, "bitbucket-cloud-hook-uuid", "Accepted X-Hook-UUID of bitbucket cloud webhooks. Can be given multiple times")
+	flags.Var(&bitbucketCloudSecrets, "bitbucket-cloud-secret", "Secret used to verify bitbucket cloud webhook signatures. Can be given multiple times for rotation")
+	flags.Var(&bitbucketCloudScopedSecrets, "bitbucket-cloud-scoped-secret", "Secret for a single bitbucket cloud workspace or repository in the form host[/workspace/repo]=secret. Can be given multiple times")
+	flags.StringVar(&bitbucketCloudPathsPolicy, "bitbucket-cloud-paths-policy", PathsPolicyTrigger, "How to treat resources with a paths filter on bitbucket cloud pushes, which carry no changed files: trigger or skip")
+	flags.Var(&giteaSecrets, "gitea-secret", "Secret used to verify gitea/forgejo webhook signatures. Can be given multiple times for rotation")
+	flags.Var(&giteaScopedSecrets, "gitea-scoped-secret", "Secret for a single gitea/forgejo host or repository in the form host[/org/repo]=secret. Can be given multiple times")
+	flags.StringVar(&azureDevOpsUser, "azure-devops-user", "", "Basic auth username expected from azure devops service hooks")
+	flags.StringVar(&azureDevOpsPassword, "azure-devops-password", "", "Basic auth password expected from azure devops service hooks")
+	flags.Var(&gerritURLs, "gerrit-url", "Base url used to resolve gerrit project names to repository urls, e.g. https://gerrit.example.com or ssh://git@gerrit.example.com:29418. Can be given multiple times")
+	flags.Var(&gerritSecrets, "gerrit-secret", "Secret expected as basic auth password (credentials in the webhook url) or in the Authorization header of gerrit webhooks. Can be given multiple times for rotation")
+	flags.Var(&gerritResourceTypes, "gerrit-resource-type", "Resource type tracking gerrit changes, triggered by patchset-created events. Can be given multiple times (default: gerrit)")
+	flags.StringVar(&azureDevOpsPathsPolicy, "azure-devops-paths-policy", PathsPolicyTrigger, "How to treat resources with a paths filter on azure devops pushes, which carry no changed files: trigger or skip")
+	flags.Var(&dockerHubTokens, "dockerhub-token", "Token expected in the token query parameter of docker hub webhooks. Can be given multiple times for rotation")
+	flags.Var(&harborSecrets, "harbor-secret", "Auth header expected from harbor webhooks. Can be given multiple times for rotation")
+	flags.Var(&distributionSecrets, "distribution-secret", "Authorization header expected from registry notifications. Can be given multiple times for rotation")
+	flags.Var(&registryAliases, "registry-host-alias", "Registry host known under another name in the form alias=host, e.g. registry:5000=registry.example.com. Can be given multiple times")
+	flags.Var(&s3Secrets, "s3-secret", "Authorization header expected from minio webhooks. Can be given multiple times for rotation")
+	flags.Var(&s3SNSTopics, "s3-sns-topic", "Accepted sns topic arn of s3 notifications, all topics are accepted if not given. Can be given multiple times")
+	flags.BoolVar(&tokenlessResources, "tokenless-resources", false, "Also cache resources without webhook token and check them via the concourse api")
+	flags.Var(&tokenlessTeams, "tokenless-team", "Only cache resources without webhook token of this team. Can be given multiple times (default: all teams)")
+	flags.Var(&tokenlessExcludedTeams, "tokenless-exclude-team", "Never cache resources without webhook token of this team. Can be given multiple times")
+	flags.StringVar(&unresolvedVarsPolicy, "unresolved-vars-policy", VarsPolicyReport, "How to treat resources with ((var)) placeholders that aren't instance vars: wildcard (match anything), skip or report (match nothing)")
 }
 
 func main() {
@@ -58,6 +123,94 @@ func main() {
 		log.Fatalf("Failed to create Concourse client")
 	}
 
+	config, err := LoadConfig(configFile)
+	if err != nil {
+		log.Fatalf("Failed to load config file: %s", err)
+	}
+
+	githubSecretStore, err := NewSecretStore(githubSecrets, githubScopedSecrets)
+	if err != nil {
+		log.Fatalf("Invalid github secrets: %s", err)
+	}
+	if githubSecretStore.Empty() {
+		log.Printf("No github secret configured. Webhook signatures are not verified")
+	}
+
+	var githubAPI *GithubAPI
+	if githubAPIToken != "" {
+		githubAPI = NewGithubAPI(githubAPIToken)
+	}
+	if !validIncompletePushPolicy(githubIncompletePushPolicy) {
+		log.Fatalf("Invalid -github-incomplete-push-policy %s, must be one of: %s, %s", githubIncompletePushPolicy, IncompletePushPolicyTrigger, IncompletePushPolicyCompare)
+	}
+	if githubIncompletePushPolicy == IncompletePushPolicyCompare && githubAPI == nil {
+		log.Fatalf("-github-incomplete-push-policy %s requires -github-api-token", IncompletePushPolicyCompare)
+	}
+
+	gitlabSecretStore, err := NewSecretStore(gitlabTokens, gitlabScopedTokens)
+	if err != nil {
+		log.Fatalf("Invalid gitlab tokens: %s", err)
+	}
+	if gitlabSecretStore.Empty() {
+		log.Printf("No gitlab token configured. Webhook tokens are not verified")
+	}
+
+	bitbucketServerSecretStore, err := NewSecretStore(bitbucketServerSecrets, nil)
+	if err != nil {
+		log.Fatalf("Invalid bitbucket server secrets: %s", err)
+	}
+	if !validPathsPolicy(bitbucketServerPathsPolicy) {
+		log.Fatalf("Invalid -bitbucket-server-paths-policy %s, must be one of: %s, %s", bitbucketServerPathsPolicy, PathsPolicyTrigger, PathsPolicySkip)
+	}
+	bitbucketCloudSecretStore, err := NewSecretStore(bitbucketCloudSecrets, bitbucketCloudScopedSecrets)
+	if err != nil {
+		log.Fatalf("Invalid bitbucket cloud secrets: %s", err)
+	}
+	if !validPathsPolicy(bitbucketCloudPathsPolicy) {
+		log.Fatalf("Invalid -bitbucket-cloud-paths-policy %s, must be one of: %s, %s", bitbucketCloudPathsPolicy, PathsPolicyTrigger, PathsPolicySkip)
+	}
+
+	giteaSecretStore, err := NewSecretStore(giteaSecrets, giteaScopedSecrets)
+	if err != nil {
+		log.Fatalf("Invalid gitea secrets: %s", err)
+	}
+
+	if !validPathsPolicy(azureDevOpsPathsPolicy) {
+		log.Fatalf("Invalid -azure-devops-paths-policy %s, must be one of: %s, %s", azureDevOpsPathsPolicy, PathsPolicyTrigger, PathsPolicySkip)
+	}
+
+	if !validVarsPolicy(unresolvedVarsPolicy) {
+		log.Fatalf("Invalid -unresolved-vars-policy %s, must be one of: %s, %s, %s", unresolvedVarsPolicy, VarsPolicyWildcard, VarsPolicySkip, VarsPolicyReport)
+	}
+
+	if err := addGitHostAliases(config.GitHostAliases); err != nil {
+		log.Fatalf("Invalid git host aliases: %s", err)
+	}
+
+	if err := parseRegistryHostAliases(registryAliases); err != nil {
+		log.Fatalf("Invalid registry host aliases: %s", err)
+	}
+
+	if err := addResourceTypeImages(config.ResourceTypes); err != nil {
+		log.Fatalf("Invalid resource types: %s", err)
+	}
+
+	gerritSecretStore, err := NewSecretStore(gerritSecrets, nil)
+	if err != nil {
+		log.Fatalf("Invalid gerrit secrets: %s", err)
+	}
+	if len(gerritURLs) > 0 && gerritSecretStore.Empty() {
+		log.Printf("No gerrit secret configured. Gerrit webhooks are not authenticated")
+	}
+
+	if len(s3SNSTopics) == 0 {
+		log.Printf("No s3 sns topic configured. Sns notifications of any topic are accepted")
+	}
+
+	if len(gerritResourceTypes) == 0 {
+		gerritResourceTypes = stringSliceFlag{"gerrit"}
+	}
+
 	var group run.Group
 
 	sigs := make(chan os.Signal, 1)
@@ -98,6 +251,14 @@ func main() {
 
 	//setup workqueue
 	requestQueue := NewRequestWorkqueue(webhookConcurrency)
+	if checkFromPushedCommit || tokenlessResources {
+		//the queue gets its own client, the token isn't shared with the cache updates
+		apiClient, err := NewConcourseClient(concourseURL, authUser, authPassword)
+		if err != nil {
+			log.Fatalf("Failed to create Concourse client")
+		}
+		requestQueue.EnableCheckAPI(apiClient, checkFromPushedCommit)
+	}
 	cancelQueue := make(chan struct{})
 	group.Add(func() error {
 		defer logend(logstart("request workqueue"))
@@ -125,8 +286,26 @@ func main() {
 			[]string{"code", "method"},
 		)
 		prometheus.Register(requestCounter)
-		ghHandler := promhttp.InstrumentHandlerCounter(requestCounter, &GithubWebhookHandler{requestQueue})
+		ghHandler := promhttp.InstrumentHandlerCounter(requestCounter, &GithubWebhookHandler{requestQueue, githubSecretStore, githubAPI, githubIncompletePushPolicy})
 		mux.Handle("/github", ghHandler)
+		mux.Handle("/bitbucket-server", promhttp.InstrumentHandlerCounter(requestCounter, &BitbucketServerWebhookHandler{requestQueue, bitbucketServerSecretStore, bitbucketServerPathsPolicy, bitbucketServerURL}))
+		mux.Handle("/azure-devops", promhttp.InstrumentHandlerCounter(requestCounter, &AzureDevOpsWebhookHandler{requestQueue, azureDevOpsUser, azureDevOpsPassword, azureDevOpsPathsPolicy}))
+		mux.Handle("/bitbucket-cloud", promhttp.InstrumentHandlerCounter(requestCounter, &BitbucketCloudWebhookHandler{requestQueue, bitbucketCloudHookUUIDs, bitbucketCloudSecretStore, bitbucketCloudPathsPolicy}))
+		if len(gerritURLs) > 0 {
+			mux.Handle("/gerrit", promhttp.InstrumentHandlerCounter(requestCounter, &GerritWebhookHandler{requestQueue, gerritURLs, gerritResourceTypes, gerritSecretStore}))
+		}
+		if config.CloudEvents != nil {
+			mux.Handle("/cloudevents", promhttp.InstrumentHandlerCounter(requestCounter, &CloudEventsHandler{requestQueue, config.CloudEvents}))
+		}
+		if len(config.Generic) > 0 {
+			mux.Handle("/generic/", promhttp.InstrumentHandlerCounter(requestCounter, NewGenericWebhookHandler(requestQueue, config.Generic)))
+		}
+		mux.Handle("/gitea", promhttp.InstrumentHandlerCounter(requestCounter, &GiteaWebhookHandler{requestQueue, giteaSecretStore}))
+		mux.Handle("/gitlab", promhttp.InstrumentHandlerCounter(requestCounter, &GitlabWebhookHandler{requestQueue, gitlabSecretStore}))
+		mux.Handle("/dockerhub", promhttp.InstrumentHandlerCounter(requestCounter, &DockerHubWebhookHandler{requestQueue, dockerHubTokens}))
+		mux.Handle("/harbor", promhttp.InstrumentHandlerCounter(requestCounter, &HarborWebhookHandler{requestQueue, harborSecrets}))
+		mux.Handle("/distribution", promhttp.InstrumentHandlerCounter(requestCounter, &DistributionWebhookHandler{requestQueue, distributionSecrets}))
+		mux.Handle("/s3", promhttp.InstrumentHandlerCounter(requestCounter, NewS3WebhookHandler(requestQueue, s3Secrets, s3SNSTopics)))
 		mux.Handle("/metrics", promhttp.Handler())
 		return http.Serve(ln, mux)
 	}, func(_ error) {
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/concourse/concourse/atc"
+)
+
+// pathFilter is the precompiled paths and ignore_paths filter of a resource.
+// Like the pathspecs the git resource passes to git log a file is matched if it matches
+// any of the include patterns, or there are none, and none of the exclude patterns.
+type pathFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// newPathFilter returns the filter of the include and exclude patterns or nil if there are none
+func newPathFilter(paths, ignorePaths []string) *pathFilter {
+	if len(paths) == 0 && len(ignorePaths) == 0 {
+		return nil
+	}
+	return &pathFilter{include: compilePathPatterns(paths), exclude: compilePathPatterns(ignorePaths)}
+}
+
+// gitPathFilter returns the paths and ignore_paths filter of a git resource or nil if it has none
+func gitPathFilter(source atc.Source) *pathFilter {
+	return newPathFilter(sourceStrings(source, "paths"), sourceStrings(source, "ignore_paths"))
+}
+
+// Match returns true if any of the files passes the filter
+func (f *pathFilter) Match(files []string) bool {
+	for _, file := range files {
+		if (len(f.include) == 0 || matchPath(f.include, file)) && !matchPath(f.exclude, file) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPath(patterns []*regexp.Regexp, file string) bool {
+	file = strings.TrimPrefix(file, "/")
+	for _, pattern := range patterns {
+		if pattern.MatchString(file) {
+			debugf("path match: %s matches %s", file, pattern)
+			return true
+		}
+	}
+	return false
+}
+
+func compilePathPatterns(patterns []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		compiled = append(compiled, compilePathPattern(pattern))
+	}
+	return compiled
+}
+
+// compilePathPattern translates a path pattern to a regular expression.
+// The pattern matches the path itself and everything below it, like git pathspecs.
+func compilePathPattern(pattern string) *regexp.Regexp {
+	pattern = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(pattern, "./"), "/"), "/")
+	re, err := regexp.Compile("^" + globExpression(pattern) + "(?:/.*)?$")
+	if err != nil {
+		//invalid character classes are matched literally
+		return regexp.MustCompile("^" + regexp.QuoteMeta(pattern) + "(?:/.*)?$")
+	}
+	return re
+}
+
+// matchGlob returns true if the whole value matches the glob pattern, like git tag --list does
+func matchGlob(pattern, value string) bool {
+	re, err := regexp.Compile("^" + globExpression(pattern) + "$")
+	if err != nil {
+		return pattern == value
+	}
+	return re.MatchString(value)
+}
+
+// globExpression translates a glob pattern to a regular expression. Like git's wildmatch without
+// pathname matching * and ? match any character including /, in addition **/ matches zero or more directories.
+func globExpression(pattern string) string {
+	var expr strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if strings.HasPrefix(pattern[i:], "**/") {
+				expr.WriteString("(?:.*/)?")
+				i += 2
+				continue
+			}
+			for i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+			}
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				expr.WriteString(regexp.QuoteMeta(pattern[i:]))
+				i = len(pattern)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return expr.String()
+}
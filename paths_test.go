@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/concourse/concourse/atc"
+)
+
+func TestMatchFilesPatterns(t *testing.T) {
+	cases := []struct {
+		pattern string
+		file    string
+		Result  bool
+	}{
+		//literal files and directories
+		{"README.md", "README.md", true},
+		{"README.md", "docs/README.md", false},
+		{"charts", "charts/values.yaml", true},
+		{"charts/", "charts/values.yaml", true},
+		{"charts/", "charts-old/values.yaml", false},
+		{"charts", "charts.yaml", false},
+		{"./charts", "charts/values.yaml", true},
+		{"/charts", "charts/values.yaml", true},
+		//globs, * and ? match across directories like git pathspecs
+		{"*.md", "README.md", true},
+		{"*.md", "docs/index.md", true},
+		{"src/*", "src/main.go", true},
+		{"src/*", "src/pkg/main.go", true},
+		{"src/*.go", "src/pkg/main.go", true},
+		{"src/*.go", "test/main.go", false},
+		{"file-?", "file-a", true},
+		{"file-?", "file-ab", false},
+		{"file-[ab]", "file-b", true},
+		{"file-[ab]", "file-c", false},
+		{"file-[!ab]", "file-c", true},
+		{"file-[a-c]", "file-c", true},
+		{"file-[", "file-[", true},
+		{"file-[z-a]", "file-a", false},
+		{`file-\*`, "file-*", true},
+		{`file-\*`, "file-a", false},
+		//globstar
+		{"charts/**/values.yaml", "charts/values.yaml", true},
+		{"charts/**/values.yaml", "charts/app/values.yaml", true},
+		{"charts/**/values.yaml", "charts/app/env/values.yaml", true},
+		{"charts/**/values.yaml", "charts/app/values.yml", false},
+		{"charts/**/values.yaml", "other/app/values.yaml", false},
+		{"**/values.yaml", "values.yaml", true},
+		{"**/values.yaml", "charts/app/values.yaml", true},
+		{"charts/**", "charts/app/values.yaml", true},
+		{"charts/**", "other/values.yaml", false},
+		{"**", "anything/at/all", true},
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "cmd/app/main.go", true},
+		{"**/*.go", "cmd/app/main.rs", false},
+	}
+	for _, c := range cases {
+		if newPathFilter([]string{c.pattern}, nil).Match([]string{c.file}) != c.Result {
+			t.Errorf("Pattern %s matching %s should be %v", c.pattern, c.file, c.Result)
+		}
+	}
+}
+
+func TestPathFilter(t *testing.T) {
+	cases := []struct {
+		source atc.Source
+		files  []string
+		Result bool
+	}{
+		//paths: only changes to the specified files yield new versions
+		{atc.Source{"paths": []interface{}{"file-c"}}, []string{"file-a", "file-b"}, false},
+		{atc.Source{"paths": []interface{}{"file-c"}}, []string{"file-a", "file-c"}, true},
+		{atc.Source{"paths": []interface{}{"file-c", "file-b"}}, []string{"file-b"}, true},
+		{atc.Source{"paths": []interface{}{"subdir/*"}}, []string{"subdir/file-a"}, true},
+		{atc.Source{"paths": []interface{}{"subdir/*"}}, []string{"file-a"}, false},
+		//ignore_paths: the inverse of paths, changes to the specified files are ignored
+		{atc.Source{"ignore_paths": []interface{}{"file-a"}}, []string{"file-a"}, false},
+		{atc.Source{"ignore_paths": []interface{}{"file-a"}}, []string{"file-a", "file-b"}, true},
+		{atc.Source{"ignore_paths": []interface{}{"file-a", "file-b"}}, []string{"file-a", "file-b"}, false},
+		{atc.Source{"ignore_paths": []interface{}{"file-*"}}, []string{"file-a", "file-b"}, false},
+		{atc.Source{"ignore_paths": []interface{}{"docs/", "**/*.md"}}, []string{"docs/index.html", "src/README.md"}, false},
+		{atc.Source{"ignore_paths": []interface{}{"docs/", "**/*.md"}}, []string{"docs/index.html", "src/main.go"}, true},
+		//paths and ignore_paths: changes to paths which aren't ignored
+		{atc.Source{"paths": []interface{}{"src/"}, "ignore_paths": []interface{}{"src/vendor/"}}, []string{"src/vendor/lib.go"}, false},
+		{atc.Source{"paths": []interface{}{"src/"}, "ignore_paths": []interface{}{"src/vendor/"}}, []string{"src/vendor/lib.go", "src/main.go"}, true},
+		{atc.Source{"paths": []interface{}{"src/"}, "ignore_paths": []interface{}{"src/vendor/"}}, []string{"src/vendor/lib.go", "README.md"}, false},
+		{atc.Source{"paths": []interface{}{"charts/**/values.yaml"}, "ignore_paths": []interface{}{"charts/test/"}}, []string{"charts/test/values.yaml"}, false},
+		{atc.Source{"paths": []interface{}{"charts/**/values.yaml"}, "ignore_paths": []interface{}{"charts/test/"}}, []string{"charts/prod/values.yaml"}, true},
+		{atc.Source{"paths": []interface{}{"file-a"}, "ignore_paths": []interface{}{"file-a"}}, []string{"file-a"}, false},
+		{atc.Source{"paths": []interface{}{"file-a"}}, nil, false},
+	}
+	for nr, c := range cases {
+		filter := gitPathFilter(c.source)
+		if filter == nil {
+			t.Fatalf("Test case %d has no filter", nr+1)
+		}
+		if filter.Match(c.files) != c.Result {
+			t.Errorf("Test case %d failed.", nr+1)
+		}
+	}
+	if filter := gitPathFilter(atc.Source{"uri": "https://git.foo/some/repo"}); filter != nil {
+		t.Errorf("Expected no filter, got %v", filter)
+	}
+}
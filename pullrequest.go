@@ -0,0 +1,191 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/concourse/concourse/atc"
+)
+
+// PullRequestEvent is the provider independent representation of a change to a pull request
+type PullRequestEvent struct {
+	Provider string
+	//RepositoryURLs contains all known clone urls of the base repository
+	RepositoryURLs []string
+	//RepositoryName is the owner/repo name of the base repository
+	RepositoryName string
+	Number         int
+	Action         string
+	BaseBranch     string
+	//Fork is set if the head of the pull request is in a different repository
+	Fork   bool
+	Labels []string
+	//Approved is set if the event is an approving review
+	Approved     bool
+	FilesChanged []string
+	FilesUnknown bool
+	PathsPolicy  string
+}
+
+func isPullRequestResource(resource atc.ResourceConfig) bool {
+	return resource.Type == "pull-request"
+}
+
+// sourceStrings returns a source field that is either a string or a list of strings
+func sourceStrings(source atc.Source, key string) []string {
+	switch v := source[key].(type) {
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// sourceInt returns a numeric source field, which might be given as a string
+func sourceInt(source atc.Source, key string) int {
+	switch v := source[key].(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	case string:
+		i, _ := strconv.Atoi(v)
+		return i
+	}
+	return 0
+}
+
+// pullRequestResourceMatchesRepository checks the uri (jtarchie/pullrequest-resource) or
+// repository/repo (telia-oss/github-pr-resource) of a pull request resource
+func pullRequestResourceMatchesRepository(resource atc.ResourceConfig, pr PullRequestEvent) bool {
+	if uri, ok := resource.Source["uri"].(string); ok && sameRepository(uri, pr.RepositoryURLs) {
+		return true
+	}
+	name, _ := resource.Source["repository"].(string)
+	if name == "" {
+		name, _ = resource.Source["repo"].(string)
+	}
+	if wildcardVar(name) {
+		return true
+	}
+	if name == "" || !strings.EqualFold(name, pr.RepositoryName) {
+		return false
+	}
+	endpoint, _ := resource.Source["v3_endpoint"].(string)
+	if endpoint == "" {
+		endpoint, _ = resource.Source["api_endpoint"].(string)
+	}
+	return githubEndpointMatches(endpoint, pr.RepositoryURLs)
+}
+
+// githubEndpointMatches returns true if the github api endpoint of a resource serves the repository.
+// An empty endpoint refers to github.com.
+func githubEndpointMatches(endpoint string, repositoryURLs []string) bool {
+	var repositoryHost string
+	for _, repositoryURL := range repositoryURLs {
+		if host, _, ok := GitRepositoryIdentity(repositoryURL); ok {
+			repositoryHost = host
+			break
+		}
+	}
+	if endpoint == "" {
+		return repositoryHost == "github.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return false
+	}
+	endpointHost := gitURLHost(u)
+	return endpointHost == repositoryHost || endpointHost == "api."+repositoryHost
+}
+
+// BroadcastPullRequest queues the webhooks of all cached pull request resources affected by the event.
+// It returns the number of resources notified.
+func BroadcastPullRequest(queue *RequestWorkqueue, pr PullRequestEvent) int {
+	notified := 0
+	ScanResourceCache(func(pipeline Pipeline, resource atc.ResourceConfig) bool {
+		if !isPullRequestResource(resource) || !pullRequestResourceMatchesRepository(resource, pr) {
+			return true
+		}
+		if reason := skipPullRequestResource(resource, pr); reason != "" {
+			log.Printf("Skipping resource %s/%s in team %s for pull request #%d: %s", pipeline.Ref(), resource.Name, pipeline.Team, pr.Number, reason)
+			return true
+		}
+		queue.AddCheck(pipeline, resource, "")
+		notified++
+		return true
+	})
+	return notified
+}
+
+// skipPullRequestResource returns the reason why the resource is not affected by the event or an empty string
+func skipPullRequestResource(resource atc.ResourceConfig, pr PullRequestEvent) string {
+	source := resource.Source
+
+	base, _ := source["base_branch"].(string)
+	if base == "" {
+		base, _ = source["base"].(string)
+	}
+	if base != "" && !wildcardVar(base) && base != pr.BaseBranch {
+		return "tracking base branch " + base
+	}
+
+	if disableForks, _ := source["disable_forks"].(bool); disableForks && pr.Fork {
+		return "forks are disabled"
+	}
+
+	labels := append(sourceStrings(source, "labels"), sourceStrings(source, "label")...)
+	if len(labels) > 0 && !containsAny(pr.Labels, labels) {
+		return "labels don't match"
+	}
+	//a label change only produces a new version for resources filtering on labels
+	if pr.Action == "labeled" && len(labels) == 0 {
+		return "no label filter"
+	}
+
+	requiredApprovals := sourceInt(source, "required_review_approvals")
+	if pr.Approved && requiredApprovals == 0 {
+		return "no review approvals required"
+	}
+	//a new pull request can't have any approvals yet
+	if pr.Action == "opened" && requiredApprovals > 0 {
+		return "review approvals required"
+	}
+
+	paths := newPathFilter(sourceStrings(source, "paths"), append(sourceStrings(source, "ignore_paths"), sourceStrings(source, "ignored_paths")...))
+	if paths == nil || pr.Approved || pr.Action == "labeled" {
+		return ""
+	}
+	if pr.FilesUnknown {
+		if pr.PathsPolicy == PathsPolicySkip {
+			return "changed files are unknown"
+		}
+		return ""
+	}
+	if paths.Match(pr.FilesChanged) {
+		return ""
+	}
+	return "path filter doesn't match"
+}
+
+func containsAny(values []string, wanted []string) bool {
+	for _, value := range values {
+		for _, w := range wanted {
+			if value == w {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/concourse/concourse/atc"
+)
+
+func TestSkipPullRequestResource(t *testing.T) {
+	pr := PullRequestEvent{
+		RepositoryURLs: []string{"https://github.com/some/repo.git"},
+		RepositoryName: "some/repo",
+		Action:         "synchronize",
+		BaseBranch:     "master",
+		Labels:         []string{"ci"},
+		FilesChanged:   []string{"docs/index.md", "src/main.go"},
+	}
+	fork := pr
+	fork.Fork = true
+	opened := pr
+	opened.Action = "opened"
+	approved := pr
+	approved.Approved = true
+	labeled := pr
+	labeled.Action = "labeled"
+	unknownFiles := pr
+	unknownFiles.FilesChanged = nil
+	unknownFiles.FilesUnknown = true
+	unknownFiles.PathsPolicy = PathsPolicySkip
+
+	cases := []struct {
+		source atc.Source
+		pr     PullRequestEvent
+		Skip   bool
+	}{
+		{atc.Source{}, pr, false},
+		{atc.Source{"base_branch": "master"}, pr, false},
+		{atc.Source{"base_branch": "develop"}, pr, true},
+		{atc.Source{"base": "develop"}, pr, true},
+		{atc.Source{"disable_forks": true}, pr, false},
+		{atc.Source{"disable_forks": true}, fork, true},
+		{atc.Source{"labels": []interface{}{"ci", "other"}}, pr, false},
+		{atc.Source{"labels": []interface{}{"other"}}, pr, true},
+		{atc.Source{"label": "ci"}, pr, false},
+		{atc.Source{}, labeled, true},
+		{atc.Source{"labels": []interface{}{"ci"}}, labeled, false},
+		{atc.Source{"required_review_approvals": float64(1)}, opened, true},
+		{atc.Source{"required_review_approvals": "2"}, pr, false},
+		{atc.Source{"required_review_approvals": float64(1)}, approved, false},
+		{atc.Source{}, approved, true},
+		{atc.Source{"paths": []interface{}{"src/"}}, pr, false},
+		{atc.Source{"paths": []interface{}{"charts/"}}, pr, true},
+		{atc.Source{"ignore_paths": []interface{}{"docs/"}}, pr, false},
+		{atc.Source{"ignore_paths": []interface{}{"docs/", "src/"}}, pr, true},
+		{atc.Source{"paths": []interface{}{"docs/"}, "ignored_paths": []interface{}{"docs/index.md"}}, pr, true},
+		{atc.Source{"paths": []interface{}{"src/"}}, unknownFiles, true},
+	}
+	for nr, c := range cases {
+		reason := skipPullRequestResource(atc.ResourceConfig{Source: c.source}, c.pr)
+		if (reason != "") != c.Skip {
+			t.Errorf("Test case %d failed. Got %q", nr+1, reason)
+		}
+	}
+}
+
+func TestPullRequestResourceMatchesRepository(t *testing.T) {
+	pr := PullRequestEvent{RepositoryURLs: []string{"https://github.com/some/repo.git"}, RepositoryName: "some/repo"}
+	ghe := PullRequestEvent{RepositoryURLs: []string{"https://ghe.foo/some/repo.git"}, RepositoryName: "some/repo"}
+
+	cases := []struct {
+		source atc.Source
+		pr     PullRequestEvent
+		Result bool
+	}{
+		{atc.Source{"repository": "some/repo"}, pr, true},
+		{atc.Source{"repository": "Some/Repo"}, pr, true},
+		{atc.Source{"repo": "some/repo"}, pr, true},
+		{atc.Source{"uri": "git@github.com:some/repo.git"}, pr, true},
+		{atc.Source{"repository": "other/repo"}, pr, false},
+		{atc.Source{"repository": "some/repo"}, ghe, false},
+		{atc.Source{"repository": "some/repo", "v3_endpoint": "https://ghe.foo/api/v3/"}, ghe, true},
+		{atc.Source{"repo": "some/repo", "api_endpoint": "https://ghe.foo/api/v3"}, ghe, true},
+		{atc.Source{"repository": "some/repo", "v3_endpoint": "https://api.github.com"}, pr, true},
+		{atc.Source{"repository": "some/repo", "v3_endpoint": "https://ghe.foo/api/v3/"}, pr, false},
+	}
+	for nr, c := range cases {
+		if pullRequestResourceMatchesRepository(atc.ResourceConfig{Source: c.source}, c.pr) != c.Result {
+			t.Errorf("Test case %d failed.", nr+1)
+		}
+	}
+}
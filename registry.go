@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/concourse/concourse/atc"
+)
+
+// dockerHubHost is the canonical host of images without a registry host
+const dockerHubHost = "docker.io"
+
+// registryHostAliases maps registry hosts to the canonical host they are known by.
+// Docker hub hosts are always mapped to docker.io.
+var registryHostAliases = map[string]string{
+	"index.docker.io":         dockerHubHost,
+	"registry-1.docker.io":    dockerHubHost,
+	"registry.hub.docker.com": dockerHubHost,
+}
+
+// ImagePushEvent is the provider independent representation of a push to a container registry
+type ImagePushEvent struct {
+	Provider string
+	//Host is the registry host, empty for docker hub
+	Host       string
+	Repository string
+	//Tag is empty for pushes by digest
+	Tag string
+}
+
+// parseRegistryHostAliases adds aliases in the form alias=host to registryHostAliases
+func parseRegistryHostAliases(aliases []string) error {
+	for _, alias := range aliases {
+		parts := strings.SplitN(alias, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("Invalid registry host alias %s, must be alias=host", alias)
+		}
+		registryHostAliases[strings.ToLower(parts[0])] = strings.ToLower(parts[1])
+	}
+	return nil
+}
+
+// ImageIdentity returns the canonical registry host and repository of an image repository reference,
+// e.g. nginx becomes docker.io library/nginx and registry:5000/foo/bar:1.0 registry:5000 foo/bar.
+func ImageIdentity(host, repository string) (string, string) {
+	repository = strings.ToLower(repository)
+	if host == "" {
+		//the first component is a host if it looks like one, see github.com/docker/distribution/reference
+		parts := strings.SplitN(repository, "/", 2)
+		if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+			host, repository = parts[0], parts[1]
+		}
+	}
+	//strip tag and digest
+	if i := strings.Index(repository, "@"); i >= 0 {
+		repository = repository[:i]
+	}
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository = repository[:i]
+	}
+	host = strings.ToLower(host)
+	if alias, ok := registryHostAliases[host]; ok {
+		host = alias
+	}
+	if host == "" {
+		host = dockerHubHost
+	}
+	if host == dockerHubHost && !strings.Contains(repository, "/") {
+		repository = "library/" + repository
+	}
+	return host, repository
+}
+
+// isImageResource returns true for resource types that track a container image in source.repository
+func isImageResource(resource atc.ResourceConfig) bool {
+	return resource.Type == "registry-image" || resource.Type == "docker-image"
+}
+
+// BroadcastImagePush queues the webhooks of all cached image resources tracking the pushed repository and tag.
+// It returns the number of resources notified.
+func BroadcastImagePush(queue *RequestWorkqueue, push ImagePushEvent) int {
+	if push.Tag == "" {
+		log.Printf("Ignoring push of %s/%s by digest", push.Host, push.Repository)
+		return 0
+	}
+	host, repository := ImageIdentity(push.Host, push.Repository)
+	log.Printf("Received %s push for %s/%s:%s", push.Provider, host, repository, push.Tag)
+	notified := 0
+	ScanResourceCache(func(pipeline Pipeline, resource atc.ResourceConfig) bool {
+		if !isImageResource(resource) {
+			return true
+		}
+		resourceRepository, _ := resource.Source["repository"].(string)
+		if resourceRepository == "" {
+			return true
+		}
+		if resourceHost, resourceRepository := ImageIdentity("", resourceRepository); !wildcardVar(resourceRepository) && (resourceHost != host || resourceRepository != repository) {
+			return true
+		}
+		if reason := skipImageTag(resource, push.Tag); reason != "" {
+			log.Printf("Skipping resource %s/%s in team %s. %s", pipeline.Ref(), resource.Name, pipeline.Team, reason)
+			return true
+		}
+		queue.AddCheck(pipeline, resource, "")
+		notified++
+		return true
+	})
+	return notified
+}
+
+// skipImageTag returns the reason why an image resource is not affected by a push of the tag or an empty string
+func skipImageTag(resource atc.ResourceConfig, tag string) string {
+	if tagRegex, _ := resource.Source["tag_regex"].(string); tagRegex != "" && !wildcardVar(tagRegex) {
+		re, err := regexp.Compile(tagRegex)
+		if err != nil {
+			return fmt.Sprintf("Invalid tag_regex %s: %s", tagRegex, err)
+		}
+		if !re.MatchString(tag) {
+			return fmt.Sprintf("Which is tracking tags matching %s", tagRegex)
+		}
+		return ""
+	}
+	resourceTag, _ := resource.Source["tag"].(string)
+	if wildcardVar(resourceTag) {
+		return ""
+	}
+	if variant, _ := resource.Source["variant"].(string); variant != "" {
+		//without a tag the variant tracks the tag <variant> and all <version>-<variant> tags
+		if resourceTag == "" && (tag == variant || strings.HasSuffix(tag, "-"+variant)) ||
+			resourceTag != "" && (tag == resourceTag || tag == resourceTag+"-"+variant) {
+			return ""
+		}
+		return fmt.Sprintf("Which is tracking variant %s", variant)
+	}
+	if resourceTag == "" {
+		resourceTag = "latest"
+	}
+	if tag != resourceTag {
+		return "Which is tracking tag " + resourceTag
+	}
+	return ""
+}
+
+// verifyAuthorizationHeader checks the Authorization header against the secrets, if any.
+// It returns the reason of the rejection or an empty string.
+func verifyAuthorizationHeader(req *http.Request, secrets []string) string {
+	if len(secrets) == 0 {
+		return ""
+	}
+	authorization := req.Header.Get("Authorization")
+	if authorization == "" {
+		return "missing"
+	}
+	if verifyToken(authorization, secrets) || verifyToken(strings.TrimPrefix(authorization, "Bearer "), secrets) {
+		return ""
+	}
+	return "invalid"
+}
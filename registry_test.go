@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/concourse/concourse/atc"
+)
+
+func TestImageIdentity(t *testing.T) {
+	registryHostAliases["registry:5000"] = "registry.example.com"
+	t.Cleanup(func() { delete(registryHostAliases, "registry:5000") })
+
+	cases := []struct {
+		host       string
+		repository string
+		Host       string
+		Repository string
+	}{
+		{"", "nginx", "docker.io", "library/nginx"},
+		{"", "nginx:1.21", "docker.io", "library/nginx"},
+		{"", "docker.io/library/nginx", "docker.io", "library/nginx"},
+		{"", "index.docker.io/concourse/concourse", "docker.io", "concourse/concourse"},
+		{"registry-1.docker.io", "concourse/concourse", "docker.io", "concourse/concourse"},
+		{"", "Registry.Example.com/Foo/Bar@sha256:abc", "registry.example.com", "foo/bar"},
+		{"", "registry:5000/foo", "registry.example.com", "foo"},
+		{"registry:5000", "foo", "registry.example.com", "foo"},
+		{"", "localhost/foo", "localhost", "foo"},
+		{"", "localhost:5000/foo:latest", "localhost:5000", "foo"},
+	}
+	for nr, c := range cases {
+		host, repository := ImageIdentity(c.host, c.repository)
+		if host != c.Host || repository != c.Repository {
+			t.Errorf("Test case %d failed. Got %s %s", nr+1, host, repository)
+		}
+	}
+}
+
+func TestSkipImageTag(t *testing.T) {
+	cases := []struct {
+		source atc.Source
+		tag    string
+		Skip   bool
+	}{
+		{atc.Source{}, "latest", false},
+		{atc.Source{}, "1.0", true},
+		{atc.Source{"tag": "1.0"}, "1.0", false},
+		{atc.Source{"tag": "1.0"}, "latest", true},
+		{atc.Source{"variant": "alpine"}, "alpine", false},
+		{atc.Source{"variant": "alpine"}, "1.2.3-alpine", false},
+		{atc.Source{"variant": "alpine"}, "1.2.3", true},
+		{atc.Source{"tag": "1.2", "variant": "alpine"}, "1.2-alpine", false},
+		{atc.Source{"tag": "1.2", "variant": "alpine"}, "1.3-alpine", true},
+		{atc.Source{"tag_regex": "^v[0-9]+$"}, "v12", false},
+		{atc.Source{"tag_regex": "^v[0-9]+$"}, "latest", true},
+		{atc.Source{"tag_regex": "("}, "latest", true},
+	}
+	for nr, c := range cases {
+		reason := skipImageTag(atc.ResourceConfig{Source: c.source}, c.tag)
+		if (reason != "") != c.Skip {
+			t.Errorf("Test case %d failed. Got %q", nr+1, reason)
+		}
+	}
+}
+
+func TestBroadcastImagePush(t *testing.T) {
+	withResourceCache(t, Pipeline{
+		ID:   1,
+		Name: "pipeline",
+		Team: "main",
+		Resources: []atc.ResourceConfig{
+			{Name: "nginx", Type: "registry-image", WebhookToken: "t", Source: atc.Source{"repository": "nginx"}},
+			{Name: "nginx-alpine", Type: "docker-image", WebhookToken: "t", Source: atc.Source{"repository": "library/nginx", "tag": "alpine"}},
+			{Name: "app", Type: "registry-image", WebhookToken: "t", Source: atc.Source{"repository": "registry.example.com/team/app", "tag_regex": "^1\\."}},
+			{Name: "git", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/nginx"}},
+		},
+	})
+
+	cases := []struct {
+		push   ImagePushEvent
+		Result int
+	}{
+		{ImagePushEvent{Host: "docker.io", Repository: "library/nginx", Tag: "latest"}, 1},
+		{ImagePushEvent{Host: "docker.io", Repository: "library/nginx", Tag: "alpine"}, 1},
+		{ImagePushEvent{Host: "docker.io", Repository: "library/nginx"}, 0},
+		{ImagePushEvent{Host: "registry.example.com", Repository: "team/app", Tag: "1.4"}, 1},
+		{ImagePushEvent{Host: "registry.example.com", Repository: "team/app", Tag: "2.0"}, 0},
+		{ImagePushEvent{Host: "other.example.com", Repository: "team/app", Tag: "1.4"}, 0},
+	}
+	queue := NewRequestWorkqueue(1)
+	for nr, c := range cases {
+		if result := BroadcastImagePush(queue, c.push); result != c.Result {
+			t.Errorf("Test case %d failed. Got %d", nr+1, result)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/concourse/concourse/atc"
+)
+
+// ReleaseEvent is the provider independent representation of a published or changed release
+type ReleaseEvent struct {
+	Provider string
+	//RepositoryURLs contains all known clone urls of the repository
+	RepositoryURLs []string
+	//RepositoryName is the owner/repo name of the repository
+	RepositoryName string
+	Action         string
+	Tag            string
+	Draft          bool
+	Prerelease     bool
+}
+
+func isReleaseResource(resource atc.ResourceConfig) bool {
+	return resource.Type == "github-release"
+}
+
+// BroadcastRelease queues the webhooks of all cached github-release resources of the repository
+// that detect the kind of release. It returns the number of resources notified.
+func BroadcastRelease(queue *RequestWorkqueue, release ReleaseEvent) int {
+	notified := 0
+	ScanResourceCache(func(pipeline Pipeline, resource atc.ResourceConfig) bool {
+		if !isReleaseResource(resource) {
+			return true
+		}
+		owner, _ := resource.Source["owner"].(string)
+		repository, _ := resource.Source["repository"].(string)
+		if !wildcardVar(owner+"/"+repository) && !strings.EqualFold(owner+"/"+repository, release.RepositoryName) {
+			return true
+		}
+		endpoint, _ := resource.Source["github_api_url"].(string)
+		if !githubEndpointMatches(endpoint, release.RepositoryURLs) {
+			return true
+		}
+		if reason := skipReleaseResource(resource, release); reason != "" {
+			log.Printf("Skipping resource %s/%s in team %s for release %s: %s", pipeline.Ref(), resource.Name, pipeline.Team, release.Tag, reason)
+			return true
+		}
+		queue.AddCheck(pipeline, resource, "")
+		notified++
+		return true
+	})
+	return notified
+}
+
+// skipReleaseResource returns the reason why a github-release resource doesn't detect the release or an empty string.
+// drafts only detects drafts, pre_release (default false) and release (default true) decide about the others.
+func skipReleaseResource(resource atc.ResourceConfig, release ReleaseEvent) string {
+	drafts, _ := resource.Source["drafts"].(bool)
+	if drafts != release.Draft {
+		if drafts {
+			return "only drafts are detected"
+		}
+		return "drafts are not detected"
+	}
+	if drafts {
+		return ""
+	}
+	if release.Prerelease {
+		if preRelease, _ := resource.Source["pre_release"].(bool); !preRelease {
+			return "pre-releases are not detected"
+		}
+		return ""
+	}
+	if detectReleases, ok := resource.Source["release"].(bool); ok && !detectReleases {
+		return "final releases are not detected"
+	}
+	return ""
+}
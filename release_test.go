@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/concourse/concourse/atc"
+)
+
+func TestSkipReleaseResource(t *testing.T) {
+	final := ReleaseEvent{Tag: "v1.0.0"}
+	prerelease := ReleaseEvent{Tag: "v1.1.0-rc1", Prerelease: true}
+	draft := ReleaseEvent{Tag: "v1.1.0", Draft: true}
+
+	cases := []struct {
+		source  atc.Source
+		release ReleaseEvent
+		Skip    bool
+	}{
+		{atc.Source{}, final, false},
+		{atc.Source{}, prerelease, true},
+		{atc.Source{}, draft, true},
+		{atc.Source{"pre_release": true}, prerelease, false},
+		{atc.Source{"pre_release": true}, final, false},
+		{atc.Source{"pre_release": true, "release": false}, final, true},
+		{atc.Source{"pre_release": true, "release": false}, prerelease, false},
+		{atc.Source{"drafts": true}, draft, false},
+		{atc.Source{"drafts": true}, final, true},
+	}
+	for nr, c := range cases {
+		reason := skipReleaseResource(atc.ResourceConfig{Source: c.source}, c.release)
+		if (reason != "") != c.Skip {
+			t.Errorf("Test case %d failed. Got %q", nr+1, reason)
+		}
+	}
+}
+
+func TestBroadcastRelease(t *testing.T) {
+	withResourceCache(t, Pipeline{
+		ID:   1,
+		Name: "pipeline",
+		Team: "main",
+		Resources: []atc.ResourceConfig{
+			{Name: "github", Type: "github-release", WebhookToken: "t", Source: atc.Source{"owner": "Some", "repository": "repo"}},
+			{Name: "enterprise", Type: "github-release", WebhookToken: "t", Source: atc.Source{"owner": "some", "repository": "repo", "github_api_url": "https://ghe.foo/api/v3/"}},
+			{Name: "git", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://github.com/some/repo.git"}},
+		},
+	})
+
+	cases := []struct {
+		release ReleaseEvent
+		Result  int
+	}{
+		{ReleaseEvent{RepositoryURLs: []string{"https://github.com/some/repo.git"}, RepositoryName: "some/repo", Tag: "v1.0.0"}, 1},
+		{ReleaseEvent{RepositoryURLs: []string{"https://ghe.foo/some/repo.git"}, RepositoryName: "some/repo", Tag: "v1.0.0"}, 1},
+		{ReleaseEvent{RepositoryURLs: []string{"https://github.com/other/repo.git"}, RepositoryName: "other/repo", Tag: "v1.0.0"}, 0},
+	}
+	queue := NewRequestWorkqueue(1)
+	for nr, c := range cases {
+		if result := BroadcastRelease(queue, c.release); result != c.Result {
+			t.Errorf("Test case %d failed. Got %d", nr+1, result)
+		}
+	}
+}
@@ -9,11 +9,18 @@ import (
 )
 
 type Pipeline struct {
-	ID        int
-	Name      string
-	Version   string
-	Team      string
-	Resources []atc.ResourceConfig
+	ID   int
+	Name string
+	//InstanceVars identify the instance of an instanced pipeline, nil for regular pipelines
+	InstanceVars atc.InstanceVars
+	Version      string
+	Team         string
+	Resources    []atc.ResourceConfig
+}
+
+// Ref returns the reference of the pipeline including its instance vars
+func (p Pipeline) Ref() atc.PipelineRef {
+	return atc.PipelineRef{Name: p.Name, InstanceVars: p.InstanceVars}
 }
 
 var (
@@ -34,6 +41,10 @@ func UpdateCache(cclient client) error {
 		return fmt.Errorf("Failed to list teams: %s", err)
 	}
 	pipelinesByID := make(map[int]atc.Pipeline, 50)
+	//the index has to follow the cache, even if the update of some teams fails
+	defer rebuildRepositoryIndex()
+	//failedTeams keep their cached pipelines until they can be listed again
+	failedTeams := map[string]bool{}
 
 	log.Printf("Updating %d teams.", len(teams))
 
@@ -41,7 +52,9 @@ func UpdateCache(cclient client) error {
 		client := client.Team(team.Name)
 		pipelines, err := client.ListPipelines()
 		if err != nil {
-			return fmt.Errorf("Failed to list pipelines: %s", err)
+			log.Printf("Failed to list pipelines of team %s: %s", team.Name, err)
+			failedTeams[team.Name] = true
+			continue
 		}
 		log.Printf("Processing %d pipeline(s) for team %s", len(pipelines), team.Name)
 
@@ -52,7 +65,7 @@ func UpdateCache(cclient client) error {
 
 			config, version, found, err := client.PipelineConfig(pipeline.Ref())
 			if err != nil {
-				log.Printf("Failed to get pipeline %s/%s: %s", pipeline.TeamName, pipeline.Name, err)
+				log.Printf("Failed to get pipeline %s/%s: %s", pipeline.TeamName, pipeline.Ref(), err)
 				continue
 			}
 			if found {
@@ -60,20 +73,35 @@ func UpdateCache(cclient client) error {
 				//add or replace cache for pipeline
 				if !inCache || cachedPipeline.(Pipeline).Version != version {
 					newCacheObj := Pipeline{
-						ID:      pipeline.ID,
-						Name:    pipeline.Name,
-						Team:    pipeline.TeamName,
-						Version: version,
+						ID:           pipeline.ID,
+						Name:         pipeline.Name,
+						InstanceVars: pipeline.InstanceVars,
+						Team:         pipeline.TeamName,
+						Version:      version,
 					}
+					keepTokenless := tokenlessResources && tokenlessTeamAllowed(pipeline.TeamName)
+					varStates := map[string]int{}
+					customTypes := customResourceTypes(config.ResourceTypes)
 					for _, resource := range config.Resources {
-						//Skip resources without webhook tokens
-						if resource.WebhookToken == "" {
+						//Skip resources without webhook tokens, unless they are checked via the api
+						if resource.WebhookToken == "" && !keepTokenless {
+							continue
+						}
+						//match resources of custom types wrapping a known resource like the base type
+						if baseType, ok := customTypes[resource.Type]; ok {
+							debugf("Treating resource %s/%s of type %s as %s", pipeline.Ref(), resource.Name, resource.Type, baseType)
+							resource.Type = baseType
+						}
+						resource, state, keep := resolveResourceVars(newCacheObj, resource)
+						varStates[state]++
+						if !keep {
 							continue
 						}
 						newCacheObj.Resources = append(newCacheObj.Resources, resource)
 					}
 					resourceCache.Store(pipeline.ID, newCacheObj)
-					log.Printf("New version detected for pipeline %s/%s. Found %d resource(s) that have a webhook token.", pipeline.TeamName, pipeline.Name, len(newCacheObj.Resources))
+					updateResourceVarsMetric(newCacheObj, varStates)
+					log.Printf("New version detected for pipeline %s/%s. Found %d resource(s) that can be triggered.", pipeline.TeamName, pipeline.Ref(), len(newCacheObj.Resources))
 				}
 			}
 		}
@@ -82,14 +110,18 @@ func UpdateCache(cclient client) error {
 	resourceCache.Range(func(key, value interface{}) bool {
 		pipelineID := key.(int)
 		cachedPipeline := value.(Pipeline)
-		if _, found := pipelinesByID[pipelineID]; !found {
-			log.Printf("Removing vanished pipeline %s/%s from cache", cachedPipeline.Team, cachedPipeline.Name)
+		if _, found := pipelinesByID[pipelineID]; !found && !failedTeams[cachedPipeline.Team] {
+			log.Printf("Removing vanished pipeline %s/%s from cache", cachedPipeline.Team, cachedPipeline.Ref())
 			resourceCache.Delete(pipelineID)
+			deleteResourceVarsMetric(cachedPipeline)
 		}
 		return true
 	})
 
 	log.Printf("Ending cache update.")
+	if len(failedTeams) > 0 {
+		return fmt.Errorf("Failed to list pipelines of %d team(s)", len(failedTeams))
+	}
 	return nil
 }
 
@@ -104,3 +136,21 @@ func ScanResourceCache(walkFn func(pipeline Pipeline, resource atc.ResourceConfi
 		return true
 	})
 }
+
+// tokenlessTeamAllowed returns true if resources without webhook token of the team are checked via the api
+func tokenlessTeamAllowed(team string) bool {
+	for _, excluded := range tokenlessExcludedTeams {
+		if excluded == team {
+			return false
+		}
+	}
+	if len(tokenlessTeams) == 0 {
+		return true
+	}
+	for _, allowed := range tokenlessTeams {
+		if allowed == team {
+			return true
+		}
+	}
+	return false
+}
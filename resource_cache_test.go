@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/concourse/concourse/atc"
+)
+
+func TestTokenlessTeamAllowed(t *testing.T) {
+	defer func(teams, excluded stringSliceFlag) {
+		tokenlessTeams, tokenlessExcludedTeams = teams, excluded
+	}(tokenlessTeams, tokenlessExcludedTeams)
+
+	cases := []struct {
+		teams    stringSliceFlag
+		excluded stringSliceFlag
+		team     string
+		Result   bool
+	}{
+		{nil, nil, "main", true},
+		{stringSliceFlag{"main"}, nil, "main", true},
+		{stringSliceFlag{"main"}, nil, "other", false},
+		{nil, stringSliceFlag{"other"}, "main", true},
+		{nil, stringSliceFlag{"other"}, "other", false},
+		{stringSliceFlag{"other"}, stringSliceFlag{"other"}, "other", false},
+	}
+	for nr, c := range cases {
+		tokenlessTeams, tokenlessExcludedTeams = c.teams, c.excluded
+		if tokenlessTeamAllowed(c.team) != c.Result {
+			t.Errorf("Test case %d failed.", nr+1)
+		}
+	}
+}
+
+func TestUpdateCacheFailingTeam(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		switch req.URL.Path {
+		case "/sky/issuer/token":
+			io.WriteString(rw, `{"access_token":"token","token_type":"Bearer","expires_in":3600}`)
+		case "/api/v1/teams":
+			io.WriteString(rw, `[{"name":"broken"},{"name":"main"}]`)
+		case "/api/v1/teams/broken/pipelines":
+			rw.WriteHeader(http.StatusInternalServerError)
+		case "/api/v1/teams/main/pipelines":
+			io.WriteString(rw, `[{"id":1,"name":"new","team_name":"main"}]`)
+		case "/api/v1/teams/main/pipelines/new/config":
+			rw.Header().Set(atc.ConfigVersionHeader, "1")
+			io.WriteString(rw, `{"config":{"resources":[{"name":"repo","type":"git","webhook_token":"t","source":{"uri":"https://git.foo/some/repo.git"}}]}}`)
+		default:
+			http.NotFound(rw, req)
+		}
+	}))
+	defer server.Close()
+
+	resource := atc.ResourceConfig{Name: "repo", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/some/repo.git"}}
+	withResourceCache(t,
+		Pipeline{ID: 2, Name: "removed", Team: "main", Resources: []atc.ResourceConfig{resource}},
+		Pipeline{ID: 3, Name: "unlisted", Team: "broken", Resources: []atc.ResourceConfig{resource}},
+	)
+
+	api, _ := NewConcourseClient(server.URL, "user", "password")
+	if err := UpdateCache(*api); err == nil {
+		t.Error("Expected error for failing team")
+	}
+
+	pipelines := map[string]bool{}
+	for _, entry := range loadRepositoryIndex().Lookup([]string{"https://git.foo/some/repo"}) {
+		pipelines[entry.pipeline.Team+"/"+entry.pipeline.Name] = true
+	}
+	if len(pipelines) != 2 || !pipelines["main/new"] || !pipelines["broken/unlisted"] {
+		t.Errorf("Unexpected indexed pipelines %v", pipelines)
+	}
+	resourceCache.Delete(1)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/concourse/concourse/atc"
+)
+
+// baseResourceTypes are the resource types the broadcaster knows how to match
+var baseResourceTypes = map[string]bool{
+	"git":            true,
+	"pull-request":   true,
+	"git-proxy":      true,
+	"registry-image": true,
+	"docker-image":   true,
+	"s3":             true,
+	"github-release": true,
+}
+
+// baseTypeNames are the sorted baseResourceTypes, images are looked up in this order
+var baseTypeNames = sortedBaseTypes()
+
+func sortedBaseTypes() []string {
+	names := make([]string, 0, len(baseResourceTypes))
+	for name := range baseResourceTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resourceTypeImages maps base resource types to the image repositories of custom resource_types wrapping them.
+// The images of the upstream resources are always included.
+var resourceTypeImages = map[string][]string{
+	"git":            {"concourse/git-resource"},
+	"pull-request":   {"teliaoss/github-pr-resource", "jtarchie/pr"},
+	"registry-image": {"concourse/registry-image-resource"},
+	"docker-image":   {"concourse/docker-image-resource"},
+	"s3":             {"concourse/s3-resource"},
+	"github-release": {"concourse/github-release-resource"},
+}
+
+// addResourceTypeImages adds the configured image repositories to resourceTypeImages.
+// An image can only wrap one base type.
+func addResourceTypeImages(types map[string][]string) error {
+	known := map[string]string{}
+	for baseType, images := range resourceTypeImages {
+		for _, image := range images {
+			host, repository := ImageIdentity("", image)
+			known[host+"/"+repository] = baseType
+		}
+	}
+	for baseType, images := range types {
+		if !baseResourceTypes[baseType] {
+			return fmt.Errorf("Unknown base resource type %s", baseType)
+		}
+		for _, image := range images {
+			host, repository := ImageIdentity("", image)
+			if knownType, ok := known[host+"/"+repository]; ok && knownType != baseType {
+				return fmt.Errorf("Image %s is configured for %s and %s", image, knownType, baseType)
+			}
+			known[host+"/"+repository] = baseType
+		}
+	}
+	for baseType, images := range types {
+		resourceTypeImages[baseType] = append(resourceTypeImages[baseType], images...)
+	}
+	return nil
+}
+
+// customResourceTypes returns the base type of each custom resource type of a pipeline whose image is known
+func customResourceTypes(resourceTypes atc.ResourceTypes) map[string]string {
+	baseTypes := map[string]string{}
+	for _, resourceType := range resourceTypes {
+		if resourceType.Type != "registry-image" && resourceType.Type != "docker-image" {
+			continue
+		}
+		repository, _ := resourceType.Source["repository"].(string)
+		if repository == "" {
+			continue
+		}
+		host, repository := ImageIdentity("", repository)
+	types:
+		for _, baseType := range baseTypeNames {
+			for _, image := range resourceTypeImages[baseType] {
+				if imageHost, imageRepository := ImageIdentity("", image); imageHost == host && imageRepository == repository {
+					baseTypes[resourceType.Name] = baseType
+					break types
+				}
+			}
+		}
+	}
+	return baseTypes
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/concourse/concourse/atc"
+)
+
+func TestCustomResourceTypes(t *testing.T) {
+	defer func(images []string) { resourceTypeImages["git"] = images }(resourceTypeImages["git"])
+	if err := addResourceTypeImages(map[string][]string{"git": {"registry.example.com/ci/git-resource", "ghcr.io/example/git-resource"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := addResourceTypeImages(map[string][]string{"s3": {"docker.io/concourse/git-resource:latest"}}); err == nil {
+		t.Errorf("Expected error for an image of two base types")
+	}
+	if err := addResourceTypeImages(map[string][]string{"svn": {"some/svn-resource"}}); err == nil {
+		t.Errorf("Expected error for unknown base type")
+	}
+
+	resourceTypes := atc.ResourceTypes{
+		{Name: "git-lfs", Type: "registry-image", Source: atc.Source{"repository": "docker.io/concourse/git-resource", "tag": "lfs"}},
+		{Name: "git-fork", Type: "docker-image", Source: atc.Source{"repository": "ghcr.io/example/git-resource"}},
+		{Name: "git-branch-heads", Type: "docker-image", Source: atc.Source{"repository": "vito/git-branch-heads-resource"}},
+		{Name: "git", Type: "registry-image", Source: atc.Source{"repository": "registry.example.com/ci/git-resource"}},
+		{Name: "pr", Type: "registry-image", Source: atc.Source{"repository": "teliaoss/github-pr-resource"}},
+		{Name: "slack", Type: "registry-image", Source: atc.Source{"repository": "cfcommunity/slack-notification-resource"}},
+		{Name: "other-registry", Type: "registry-image", Source: atc.Source{"repository": "registry.example.com/concourse/git-resource"}},
+		{Name: "custom-base", Type: "git-lfs", Source: atc.Source{"repository": "concourse/git-resource"}},
+	}
+	expected := map[string]string{
+		"git-lfs":  "git",
+		"git-fork": "git",
+		"git":      "git",
+		"pr":       "pull-request",
+	}
+	if result := customResourceTypes(resourceTypes); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}
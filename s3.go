@@ -0,0 +1,360 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/concourse/concourse/atc"
+)
+
+// snsHostPattern matches the hosts of sns signing certificates and subscription urls
+var snsHostPattern = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// S3ObjectEvent is the provider independent representation of a created object in a bucket
+type S3ObjectEvent struct {
+	//Provider is sns for aws notifications and minio for minio webhooks
+	Provider  string
+	EventName string
+	Bucket    string
+	Key       string
+}
+
+// S3WebhookHandler handles s3 event notifications delivered by aws sns or the minio webhook target
+type S3WebhookHandler struct {
+	queue *RequestWorkqueue
+	//secrets are the accepted values of the Authorization header sent by minio
+	secrets []string
+	//topics are the accepted sns topic arns, all topics are accepted if empty
+	topics []string
+	client *http.Client
+	//certificates caches the sns signing certificates by url
+	certificates sync.Map
+}
+
+// NewS3WebhookHandler creates a S3WebhookHandler
+func NewS3WebhookHandler(queue *RequestWorkqueue, secrets, topics []string) *S3WebhookHandler {
+	return &S3WebhookHandler{
+		queue:   queue,
+		secrets: secrets,
+		topics:  topics,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type s3EventRecords struct {
+	//EventName is only sent by minio
+	EventName string `json:"EventName"`
+	//Event is set for the s3:TestEvent sent when a notification is configured
+	Event   string `json:"Event"`
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+type snsMessage struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	SubscribeURL     string `json:"SubscribeURL"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+}
+
+func (s3 *S3WebhookHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	body, err := readBody(rw, req)
+	if err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to read request body: %s", err)
+		return
+	}
+
+	provider := "minio"
+	if req.Header.Get("X-Amz-Sns-Message-Type") != "" {
+		provider = "sns"
+		body, err = s3.handleSNS(rw, body)
+		if err != nil || body == nil {
+			return
+		}
+	} else if reason := verifyAuthorizationHeader(req, s3.secrets); reason != "" {
+		signatureRejections.WithLabelValues("minio", reason).Inc()
+		http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+		log.Printf("Rejecting minio webhook: %s authorization header", reason)
+		return
+	}
+
+	events, err := parseS3Events(provider, body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		log.Printf("Failed to parse %s s3 notification: %s", provider, err)
+		return
+	}
+	notified := 0
+	for _, event := range events {
+		notified += BroadcastS3Object(s3.queue, event)
+	}
+	fmt.Fprintf(rw, "ok: %d resource(s) notified\n", notified)
+}
+
+// handleSNS verifies the sns envelope and confirms subscriptions.
+// It returns the embedded s3 notification or nil if there is none.
+func (s3 *S3WebhookHandler) handleSNS(rw http.ResponseWriter, body []byte) ([]byte, error) {
+	var message snsMessage
+	if err := json.Unmarshal(body, &message); err != nil {
+		rw.WriteHeader(400)
+		log.Printf("Failed to parse sns message: %s", err)
+		return nil, err
+	}
+	if len(s3.topics) > 0 && !containsAny([]string{message.TopicArn}, s3.topics) {
+		signatureRejections.WithLabelValues("sns", "topic").Inc()
+		http.Error(rw, "Topic not allowed", http.StatusForbidden)
+		log.Printf("Rejecting sns message %s from topic %s", message.MessageID, message.TopicArn)
+		return nil, fmt.Errorf("Topic %s not allowed", message.TopicArn)
+	}
+	if err := s3.verifySNSSignature(message); err != nil {
+		signatureRejections.WithLabelValues("sns", "invalid").Inc()
+		http.Error(rw, "Invalid signature", http.StatusUnauthorized)
+		log.Printf("Rejecting sns message %s from topic %s: %s", message.MessageID, message.TopicArn, err)
+		return nil, err
+	}
+
+	switch message.Type {
+	case "Notification":
+		return []byte(message.Message), nil
+	case "SubscriptionConfirmation":
+		if err := s3.confirmSubscription(message.SubscribeURL); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadGateway)
+			log.Printf("Failed to confirm sns subscription to topic %s: %s", message.TopicArn, err)
+			return nil, err
+		}
+		log.Printf("Confirmed sns subscription to topic %s", message.TopicArn)
+		fmt.Fprintf(rw, "ok: subscription confirmed\n")
+	default:
+		log.Printf("Ignoring sns message %s of type %s from topic %s", message.MessageID, message.Type, message.TopicArn)
+		rw.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(rw, "ignored: message type %s is not handled\n", message.Type)
+	}
+	return nil, nil
+}
+
+// validSNSURL returns true for https urls pointing to an sns endpoint
+func validSNSURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && u.Scheme == "https" && snsHostPattern.MatchString(u.Host)
+}
+
+func (s3 *S3WebhookHandler) confirmSubscription(subscribeURL string) error {
+	if !validSNSURL(subscribeURL) {
+		return fmt.Errorf("Invalid SubscribeURL %s", subscribeURL)
+	}
+	resp, err := s3.client.Get(subscribeURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Subscription confirmation returned %s", resp.Status)
+	}
+	return nil
+}
+
+// verifySNSSignature checks the signature of a sns message, see
+// https://docs.aws.amazon.com/sns/latest/dg/sns-verify-signature-of-message.html
+func (s3 *S3WebhookHandler) verifySNSSignature(message snsMessage) error {
+	var fields []string
+	switch message.Type {
+	case "Notification":
+		fields = []string{"Message", message.Message, "MessageId", message.MessageID}
+		if message.Subject != "" {
+			fields = append(fields, "Subject", message.Subject)
+		}
+		fields = append(fields, "Timestamp", message.Timestamp, "TopicArn", message.TopicArn, "Type", message.Type)
+	case "SubscriptionConfirmation", "UnsubscribeConfirmation":
+		fields = []string{"Message", message.Message, "MessageId", message.MessageID, "SubscribeURL", message.SubscribeURL,
+			"Timestamp", message.Timestamp, "Token", message.Token, "TopicArn", message.TopicArn, "Type", message.Type}
+	default:
+		return fmt.Errorf("Unknown message type %s", message.Type)
+	}
+	var stringToSign strings.Builder
+	for _, field := range fields {
+		stringToSign.WriteString(field)
+		stringToSign.WriteString("\n")
+	}
+
+	var hash crypto.Hash
+	var digest []byte
+	switch message.SignatureVersion {
+	case "1":
+		sum := sha1.Sum([]byte(stringToSign.String()))
+		hash, digest = crypto.SHA1, sum[:]
+	case "2":
+		sum := sha256.Sum256([]byte(stringToSign.String()))
+		hash, digest = crypto.SHA256, sum[:]
+	default:
+		return fmt.Errorf("Unsupported signature version %s", message.SignatureVersion)
+	}
+	signature, err := base64.StdEncoding.DecodeString(message.Signature)
+	if err != nil {
+		return fmt.Errorf("Invalid signature encoding: %s", err)
+	}
+	cert, err := s3.signingCertificate(message.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("Signing certificate has no rsa key")
+	}
+	return rsa.VerifyPKCS1v15(publicKey, hash, digest, signature)
+}
+
+// signingCertificate fetches the sns signing certificate, certificates are cached
+func (s3 *S3WebhookHandler) signingCertificate(certURL string) (*x509.Certificate, error) {
+	if cert, ok := s3.certificates.Load(certURL); ok {
+		return cert.(*x509.Certificate), nil
+	}
+	if !validSNSURL(certURL) {
+		return nil, fmt.Errorf("Invalid SigningCertURL %s", certURL)
+	}
+	resp, err := s3.client.Get(certURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Fetching signing certificate returned %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("Invalid signing certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	s3.certificates.Store(certURL, cert)
+	return cert, nil
+}
+
+// parseS3Events returns the created objects of a s3 notification, other events are skipped
+func parseS3Events(provider string, payload []byte) ([]S3ObjectEvent, error) {
+	var records s3EventRecords
+	if err := json.Unmarshal(payload, &records); err != nil {
+		return nil, err
+	}
+	if records.Event == "s3:TestEvent" {
+		log.Printf("Received %s s3 test event", provider)
+		return nil, nil
+	}
+	var events []S3ObjectEvent
+	for _, record := range records.Records {
+		if !strings.HasPrefix(strings.TrimPrefix(record.EventName, "s3:"), "ObjectCreated:") {
+			debugf("Skipping %s s3 event %s for %s", provider, record.EventName, record.S3.Object.Key)
+			continue
+		}
+		//object keys are url encoded
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid object key %s: %s", record.S3.Object.Key, err)
+		}
+		events = append(events, S3ObjectEvent{
+			Provider:  provider,
+			EventName: record.EventName,
+			Bucket:    record.S3.Bucket.Name,
+			Key:       key,
+		})
+	}
+	return events, nil
+}
+
+func isS3Resource(resource atc.ResourceConfig) bool {
+	return resource.Type == "s3"
+}
+
+// BroadcastS3Object queues the webhooks of all cached s3 resources tracking the created object.
+// Aws notifications only trigger resources without a custom endpoint, minio notifications only ones with.
+// It returns the number of resources notified.
+func BroadcastS3Object(queue *RequestWorkqueue, event S3ObjectEvent) int {
+	log.Printf("Received %s s3 notification %s for %s/%s", event.Provider, event.EventName, event.Bucket, event.Key)
+	notified := 0
+	ScanResourceCache(func(pipeline Pipeline, resource atc.ResourceConfig) bool {
+		if !isS3Resource(resource) {
+			return true
+		}
+		if bucket, _ := resource.Source["bucket"].(string); bucket != event.Bucket && !wildcardVar(bucket) {
+			return true
+		}
+		endpoint, _ := resource.Source["endpoint"].(string)
+		if customEndpoint := endpoint != "" && !strings.Contains(endpoint, "amazonaws.com"); customEndpoint != (event.Provider == "minio") {
+			return true
+		}
+		if reason := skipS3Object(resource, event.Key); reason != "" {
+			debugf("Skipping resource %s/%s in team %s. %s", pipeline.Ref(), resource.Name, pipeline.Team, reason)
+			return true
+		}
+		queue.AddCheck(pipeline, resource, "")
+		notified++
+		return true
+	})
+	return notified
+}
+
+// skipS3Object returns the reason why a s3 resource is not affected by the created object or an empty string
+func skipS3Object(resource atc.ResourceConfig, key string) string {
+	if versionedFile, _ := resource.Source["versioned_file"].(string); versionedFile != "" {
+		if wildcardVar(versionedFile) {
+			return ""
+		}
+		if key != versionedFile {
+			return "Which is tracking file " + versionedFile
+		}
+		return ""
+	}
+	pattern, _ := resource.Source["regexp"].(string)
+	if pattern == "" {
+		return "Which has neither regexp nor versioned_file"
+	}
+	if wildcardVar(pattern) {
+		return ""
+	}
+	//like the s3 resource the whole key has to match
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return fmt.Sprintf("Invalid regexp %s: %s", pattern, err)
+	}
+	if !re.MatchString(key) {
+		return "Which is tracking files matching " + pattern
+	}
+	return ""
+}
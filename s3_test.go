@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/concourse/concourse/atc"
+)
+
+type recordingTransport struct {
+	requests []string
+}
+
+func (r *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.requests = append(r.requests, req.URL.String())
+	return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("")), Header: http.Header{}}, nil
+}
+
+// snsTestHandler returns a handler trusting a generated signing certificate and a function to sign messages with it
+func snsTestHandler(t *testing.T, topics []string) (*S3WebhookHandler, *recordingTransport, func(snsMessage) string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "sns.amazonaws.com"}, NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	certURL := "https://sns.eu-west-1.amazonaws.com/SimpleNotificationService-test.pem"
+
+	transport := &recordingTransport{}
+	handler := NewS3WebhookHandler(NewRequestWorkqueue(1), nil, topics)
+	handler.client = &http.Client{Transport: transport}
+	handler.certificates.Store(certURL, cert)
+
+	sign := func(message snsMessage) string {
+		message.SignatureVersion = "2"
+		message.SigningCertURL = certURL
+		stringToSign := "Message\n" + message.Message + "\nMessageId\n" + message.MessageID + "\n"
+		if message.Type == "Notification" {
+			stringToSign += "Timestamp\n" + message.Timestamp + "\nTopicArn\n" + message.TopicArn + "\nType\n" + message.Type + "\n"
+		} else {
+			stringToSign += "SubscribeURL\n" + message.SubscribeURL + "\nTimestamp\n" + message.Timestamp + "\nToken\n" + message.Token + "\nTopicArn\n" + message.TopicArn + "\nType\n" + message.Type + "\n"
+		}
+		digest := sha256.Sum256([]byte(stringToSign))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		message.Signature = base64.StdEncoding.EncodeToString(signature)
+		body, _ := json.Marshal(message)
+		return string(body)
+	}
+	return handler, transport, sign
+}
+
+func TestS3WebhookHandlerSNS(t *testing.T) {
+	withResourceCache(t, Pipeline{
+		ID:   1,
+		Name: "pipeline",
+		Team: "main",
+		Resources: []atc.ResourceConfig{
+			{Name: "release", Type: "s3", WebhookToken: "t", Source: atc.Source{"bucket": "releases", "regexp": "app/app-(.*).tgz"}},
+			{Name: "minio", Type: "s3", WebhookToken: "t", Source: atc.Source{"bucket": "releases", "regexp": "app/app-(.*).tgz", "endpoint": "https://minio.example.com"}},
+		},
+	})
+	topic := "arn:aws:sns:eu-west-1:123456789012:releases"
+	notification := `{"Records":[{"eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"releases"},"object":{"key":"app/app-1.0.0.tgz"}}}]}`
+
+	handler, transport, sign := snsTestHandler(t, []string{topic})
+	message := snsMessage{Type: "Notification", MessageID: "1", TopicArn: topic, Message: notification, Timestamp: "2021-11-01T00:00:00.000Z"}
+	confirmation := snsMessage{Type: "SubscriptionConfirmation", MessageID: "2", TopicArn: topic, Token: "abc", Message: "confirm", Timestamp: "2021-11-01T00:00:00.000Z",
+		SubscribeURL: "https://sns.eu-west-1.amazonaws.com/?Action=ConfirmSubscription&TopicArn=" + topic + "&Token=abc"}
+	tampered := strings.Replace(sign(message), "1.0.0", "6.6.6", 1)
+	otherTopic := message
+	otherTopic.TopicArn = "arn:aws:sns:eu-west-1:123456789012:other"
+
+	cases := []struct {
+		body     string
+		Status   int
+		Queued   int
+		Requests int
+	}{
+		{sign(message), 200, 1, 0},
+		{tampered, 401, 0, 0},
+		{sign(otherTopic), 403, 0, 0},
+		{sign(confirmation), 200, 0, 1},
+	}
+	for nr, c := range cases {
+		handler.queue = NewRequestWorkqueue(1)
+		transport.requests = nil
+		req := httptest.NewRequest("POST", "/s3", strings.NewReader(c.body))
+		req.Header.Set("X-Amz-Sns-Message-Type", "Notification")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Code != c.Status || handler.queue.queue.Len() != c.Queued || len(transport.requests) != c.Requests {
+			t.Errorf("Test case %d failed. Got %d, %d queued, %d requests", nr+1, rw.Code, handler.queue.queue.Len(), len(transport.requests))
+		}
+	}
+}
+
+func TestS3WebhookHandlerMinio(t *testing.T) {
+	withResourceCache(t, Pipeline{
+		ID:   1,
+		Name: "pipeline",
+		Team: "main",
+		Resources: []atc.ResourceConfig{
+			{Name: "aws", Type: "s3", WebhookToken: "t", Source: atc.Source{"bucket": "releases", "versioned_file": "app/app.tgz"}},
+			{Name: "minio", Type: "s3", WebhookToken: "t", Source: atc.Source{"bucket": "releases", "versioned_file": "app/app.tgz", "endpoint": "https://minio.example.com"}},
+			{Name: "other", Type: "s3", WebhookToken: "t", Source: atc.Source{"bucket": "releases", "versioned_file": "app/other.tgz", "endpoint": "https://minio.example.com"}},
+		},
+	})
+	//recorded from minio RELEASE.2021-10-27
+	body := `{"EventName":"s3:ObjectCreated:Put","Key":"releases/app/app.tgz","Records":[{"eventVersion":"2.0","eventSource":"minio:s3","awsRegion":"","eventTime":"2021-11-01T10:00:00.000Z","eventName":"s3:ObjectCreated:Put","s3":{"s3SchemaVersion":"1.0","configurationId":"Config","bucket":{"name":"releases","arn":"arn:aws:s3:::releases"},"object":{"key":"app%2Fapp.tgz","size":1024,"versionId":"1"}},"source":{"host":"10.0.0.1","port":"","userAgent":"MinIO (linux; amd64) minio-go/v7.0.15"}}]}`
+
+	cases := []struct {
+		authorization string
+		body          string
+		Status        int
+		Queued        int
+	}{
+		{"Bearer s3cr3t", body, 200, 1},
+		{"Bearer wrong", body, 401, 0},
+		{"", body, 401, 0},
+		{"Bearer s3cr3t", strings.Replace(body, "ObjectCreated:Put", "ObjectRemoved:Delete", -1), 200, 0},
+		{"Bearer s3cr3t", `not json`, 400, 0},
+	}
+	for nr, c := range cases {
+		handler := NewS3WebhookHandler(NewRequestWorkqueue(1), []string{"s3cr3t"}, nil)
+		req := httptest.NewRequest("POST", "/s3", strings.NewReader(c.body))
+		if c.authorization != "" {
+			req.Header.Set("Authorization", c.authorization)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Code != c.Status || handler.queue.queue.Len() != c.Queued {
+			t.Errorf("Test case %d failed. Got %d, %d queued", nr+1, rw.Code, handler.queue.queue.Len())
+		}
+	}
+}
+
+func TestSkipS3Object(t *testing.T) {
+	cases := []struct {
+		source  atc.Source
+		key     string
+		Skipped bool
+	}{
+		{atc.Source{"versioned_file": "app/app.tgz"}, "app/app.tgz", false},
+		{atc.Source{"versioned_file": "app/app.tgz"}, "app/app.tgz.sha1", true},
+		{atc.Source{"regexp": "release-(.*).tgz"}, "release-1.tgz", false},
+		{atc.Source{"regexp": "release-(.*).tgz"}, "release-1.tgz.sha1", true},
+		{atc.Source{"regexp": "release-(.*).tgz"}, "other/release-1.tgz", true},
+		{atc.Source{"regexp": "app/app-(.*).tgz|app/app-(.*).zip"}, "app/app-1.zip", false},
+		{atc.Source{"regexp": "app-(.*"}, "app-1", true},
+		{atc.Source{}, "app/app.tgz", true},
+	}
+	for nr, c := range cases {
+		if (skipS3Object(atc.ResourceConfig{Name: "release", Type: "s3", Source: c.source}, c.key) != "") != c.Skipped {
+			t.Errorf("Test case %d failed.", nr+1)
+		}
+	}
+}
+
+func TestValidSNSURL(t *testing.T) {
+	cases := []struct {
+		url    string
+		Result bool
+	}{
+		{"https://sns.eu-west-1.amazonaws.com/SimpleNotificationService-abc.pem", true},
+		{"https://sns.cn-north-1.amazonaws.com.cn/SimpleNotificationService-abc.pem", true},
+		{"http://sns.eu-west-1.amazonaws.com/SimpleNotificationService-abc.pem", false},
+		{"https://sns.eu-west-1.amazonaws.com.evil.com/cert.pem", false},
+		{"https://evil.com/sns.eu-west-1.amazonaws.com/cert.pem", false},
+	}
+	for nr, c := range cases {
+		if validSNSURL(c.url) != c.Result {
+			t.Errorf("Test case %d failed.", nr+1)
+		}
+	}
+}
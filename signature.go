@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var signatureRejections = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Subsystem: "webhook",
+		Name:      "signature_rejections_total",
+		Help:      "Total number of incoming webhooks rejected due to a missing or invalid signature",
+	},
+	[]string{"provider", "reason"},
+)
+
+func init() {
+	prometheus.Register(signatureRejections)
+}
+
+// SecretStore holds the shared secrets used to verify incoming webhooks.
+// Several secrets can be valid at the same time to allow rotation.
+type SecretStore struct {
+	global []string
+	scoped map[string][]string
+}
+
+// NewSecretStore creates a SecretStore from a list of global secrets and a list of scoped
+// secrets in the form `scope=secret`. A scope is either a host (github.example.com) or
+// a repository (github.example.com/org/repo).
+func NewSecretStore(global []string, scoped []string) (*SecretStore, error) {
+	s := &SecretStore{scoped: map[string][]string{}}
+	for _, secret := range global {
+		if secret == "" {
+			return nil, fmt.Errorf("Empty secret given")
+		}
+		s.global = append(s.global, secret)
+	}
+	for _, entry := range scoped {
+		idx := strings.Index(entry, "=")
+		if idx <= 0 || idx == len(entry)-1 {
+			return nil, fmt.Errorf("Invalid scoped secret, expected scope=secret")
+		}
+		scope := strings.ToLower(strings.Trim(entry[:idx], "/"))
+		s.scoped[scope] = append(s.scoped[scope], entry[idx+1:])
+	}
+	return s, nil
+}
+
+// Empty returns true if no secrets are configured at all
+func (s *SecretStore) Empty() bool {
+	return s == nil || (len(s.global) == 0 && len(s.scoped) == 0)
+}
+
+// Secrets returns the secrets valid for the given host and repository.
+// The most specific scope that has secrets configured wins.
+func (s *SecretStore) Secrets(host, repository string) []string {
+	if s == nil {
+		return nil
+	}
+	host = strings.ToLower(host)
+	if host != "" && repository != "" {
+		if secrets, ok := s.scoped[host+"/"+strings.ToLower(strings.Trim(repository, "/"))]; ok {
+			return secrets
+		}
+	}
+	if secrets, ok := s.scoped[host]; ok && host != "" {
+		return secrets
+	}
+	return s.global
+}
+
+// verifyHMAC checks a hex encoded HMAC signature (optionally prefixed, e.g. `sha256=`) of the body against the given secrets
+func verifyHMAC(hashFunc func() hash.Hash, prefix, signature string, body []byte, secrets []string) bool {
+	if !strings.HasPrefix(signature, prefix) {
+		return false
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
+	if err != nil {
+		return false
+	}
+	for _, secret := range secrets {
+		mac := hmac.New(hashFunc, []byte(secret))
+		mac.Write(body)
+		if hmac.Equal(mac.Sum(nil), expected) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifySHA256Signature verifies a GitHub style `sha256=<hex>` signature
+func verifySHA256Signature(signature string, body []byte, secrets []string) bool {
+	return verifyHMAC(sha256.New, "sha256=", signature, body, secrets)
+}
+
+// verifyToken compares a plain shared token (e.g. X-Gitlab-Token) against the given secrets
+func verifyToken(token string, secrets []string) bool {
+	for _, secret := range secrets {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVerifySHA256Signature(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/master"}`)
+	//signature of body with secret "s3cr3t"
+	signature := "sha256=3f8a37868f8b35d1d90f0d622c99ceef02958d776348ae740ab66b0d58dbe787"
+
+	cases := []struct {
+		signature string
+		secrets   []string
+		Result    bool
+	}{
+		{signature, []string{"s3cr3t"}, true},
+		{signature, []string{"old", "s3cr3t"}, true},
+		{signature, []string{"wrong"}, false},
+		{signature, nil, false},
+		{"", []string{"s3cr3t"}, false},
+		{"sha1=3f8a37868f8b35d1d90f0d622c99ceef02958d776348ae740ab66b0d58dbe787", []string{"s3cr3t"}, false},
+		{"sha256=nothex", []string{"s3cr3t"}, false},
+	}
+	for nr, c := range cases {
+		if verifySHA256Signature(c.signature, body, c.secrets) != c.Result {
+			t.Errorf("Test case %d failed.", nr+1)
+		}
+	}
+}
+
+func TestSecretStore(t *testing.T) {
+	store, err := NewSecretStore(
+		[]string{"global1", "global2"},
+		[]string{"GHE.example.com=host", "ghe.example.com/some/repo=repo", "ghe.example.com/some/repo=repo2"},
+	)
+	if err != nil {
+		t.Fatalf("Failed to create secret store: %s", err)
+	}
+
+	cases := []struct {
+		host       string
+		repository string
+		Result     []string
+	}{
+		{"github.com", "some/repo", []string{"global1", "global2"}},
+		{"ghe.example.com", "other/repo", []string{"host"}},
+		{"ghe.example.com", "Some/Repo", []string{"repo", "repo2"}},
+		{"", "", []string{"global1", "global2"}},
+	}
+	for nr, c := range cases {
+		if result := store.Secrets(c.host, c.repository); !reflect.DeepEqual(result, c.Result) {
+			t.Errorf("Test case %d failed. Got %v", nr+1, result)
+		}
+	}
+
+	for _, invalid := range []string{"noscope", "=secret", "scope="} {
+		if _, err := NewSecretStore(nil, []string{invalid}); err == nil {
+			t.Errorf("Expected error for scoped secret %q", invalid)
+		}
+	}
+}
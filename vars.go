@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/concourse/concourse/atc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Policies for resources with ((var)) placeholders that can't be resolved from the instance vars
+const (
+	//VarsPolicyWildcard treats unresolved fields as matching anything
+	VarsPolicyWildcard = "wildcard"
+	//VarsPolicySkip removes resources with unresolved placeholders from the cache
+	VarsPolicySkip = "skip"
+	//VarsPolicyReport keeps the placeholders, which don't match anything, and reports them
+	VarsPolicyReport = "report"
+)
+
+// Resolution states of the vars of a cached resource
+const (
+	varsNone       = "none"
+	varsResolved   = "resolved"
+	varsUnresolved = "unresolved"
+)
+
+func validVarsPolicy(policy string) bool {
+	return policy == VarsPolicyWildcard || policy == VarsPolicySkip || policy == VarsPolicyReport
+}
+
+// varPattern matches ((var)) placeholders, see https://concourse-ci.org/vars.html
+var varPattern = regexp.MustCompile(`\(\(([^()]+)\)\)`)
+
+var cachedResourceVars = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "resource_cache_resources",
+		Help: "Number of cached resources per pipeline by resolution of their ((var)) placeholders (none, resolved, unresolved)",
+	},
+	[]string{"team", "pipeline", "vars"},
+)
+
+func init() {
+	prometheus.MustRegister(cachedResourceVars)
+}
+
+// resolveVar looks up a var like stage or stage.region in the instance vars.
+// Vars of credential managers (source:var) are never resolved.
+func resolveVar(name string, instanceVars atc.InstanceVars) (interface{}, bool) {
+	name = strings.TrimSpace(name)
+	if strings.Contains(name, ":") {
+		return nil, false
+	}
+	var value interface{} = map[string]interface{}(instanceVars)
+	for _, field := range strings.Split(name, ".") {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = fields[strings.Trim(field, `"`)]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// resolveVars substitutes the ((var)) placeholders of a value with the instance vars.
+// It returns the resolved copy and the names of the placeholders that could and couldn't be resolved.
+func resolveVars(value interface{}, instanceVars atc.InstanceVars) (result interface{}, resolved []string, unresolved []string) {
+	switch v := value.(type) {
+	case string:
+		//a value consisting of a single var keeps the type of the var
+		if match := varPattern.FindStringSubmatch(v); match != nil && match[0] == v {
+			if resolvedValue, ok := resolveVar(match[1], instanceVars); ok {
+				return resolvedValue, []string{match[1]}, nil
+			}
+			return v, nil, []string{match[1]}
+		}
+		result := varPattern.ReplaceAllStringFunc(v, func(placeholder string) string {
+			name := varPattern.FindStringSubmatch(placeholder)[1]
+			resolvedValue, ok := resolveVar(name, instanceVars)
+			if !ok {
+				unresolved = append(unresolved, name)
+				return placeholder
+			}
+			resolved = append(resolved, name)
+			if s, ok := resolvedValue.(string); ok {
+				return s
+			}
+			encoded, _ := json.Marshal(resolvedValue)
+			return string(encoded)
+		})
+		return result, resolved, unresolved
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			var r, u []string
+			result[key], r, u = resolveVars(item, instanceVars)
+			resolved, unresolved = append(resolved, r...), append(unresolved, u...)
+		}
+		return result, resolved, unresolved
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			var r, u []string
+			result[i], r, u = resolveVars(item, instanceVars)
+			resolved, unresolved = append(resolved, r...), append(unresolved, u...)
+		}
+		return result, resolved, unresolved
+	}
+	return value, nil, nil
+}
+
+// resolveResourceVars substitutes the ((var)) placeholders of the resource source with the instance vars of the pipeline.
+// It returns the resource and the resolution state, the resource should be dropped if keep is false.
+func resolveResourceVars(pipeline Pipeline, resource atc.ResourceConfig) (result atc.ResourceConfig, state string, keep bool) {
+	source, resolved, unresolved := resolveVars(map[string]interface{}(resource.Source), pipeline.InstanceVars)
+	resource.Source = atc.Source(source.(map[string]interface{}))
+	switch {
+	case len(unresolved) > 0:
+		state = varsUnresolved
+	case len(resolved) > 0:
+		state = varsResolved
+	default:
+		return resource, varsNone, true
+	}
+	if state == varsResolved {
+		debugf("Resolved vars %s of resource %s/%s in team %s", strings.Join(resolved, ", "), pipeline.Ref(), resource.Name, pipeline.Team)
+		return resource, state, true
+	}
+	switch unresolvedVarsPolicy {
+	case VarsPolicySkip:
+		log.Printf("Skipping resource %s/%s in team %s, unresolved vars: %s", pipeline.Ref(), resource.Name, pipeline.Team, strings.Join(unresolved, ", "))
+		return resource, state, false
+	case VarsPolicyWildcard:
+		log.Printf("Resource %s/%s in team %s has unresolved vars %s, which match anything", pipeline.Ref(), resource.Name, pipeline.Team, strings.Join(unresolved, ", "))
+	default:
+		log.Printf("Resource %s/%s in team %s has unresolved vars %s, which don't match anything", pipeline.Ref(), resource.Name, pipeline.Team, strings.Join(unresolved, ", "))
+	}
+	return resource, state, true
+}
+
+// wildcardVar returns true if the source value is an unresolved placeholder that matches anything
+func wildcardVar(value string) bool {
+	return unresolvedVarsPolicy == VarsPolicyWildcard && varPattern.MatchString(value)
+}
+
+// updateResourceVarsMetric sets the number of resources per resolution state of a cached pipeline
+func updateResourceVarsMetric(pipeline Pipeline, states map[string]int) {
+	for _, state := range []string{varsNone, varsResolved, varsUnresolved} {
+		cachedResourceVars.WithLabelValues(pipeline.Team, pipeline.Ref().String(), state).Set(float64(states[state]))
+	}
+}
+
+// deleteResourceVarsMetric removes the metrics of a pipeline that vanished from the cache
+func deleteResourceVarsMetric(pipeline Pipeline) {
+	for _, state := range []string{varsNone, varsResolved, varsUnresolved} {
+		cachedResourceVars.DeleteLabelValues(pipeline.Team, pipeline.Ref().String(), state)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/concourse/concourse/atc"
+)
+
+func TestResolveVars(t *testing.T) {
+	instanceVars := atc.InstanceVars{
+		"repo_uri": "https://git.foo/some/repo.git",
+		"branch":   "release",
+		"stage":    map[string]interface{}{"region": "eu-de-1", "number": float64(2)},
+		"paths":    []interface{}{"charts/"},
+	}
+
+	cases := []struct {
+		value      interface{}
+		Result     interface{}
+		Unresolved []string
+	}{
+		{"((repo_uri))", "https://git.foo/some/repo.git", nil},
+		{"(( branch ))", "release", nil},
+		{"((paths))", []interface{}{"charts/"}, nil},
+		{"values/((stage.region))/((stage.number)).yaml", "values/eu-de-1/2.yaml", nil},
+		{"((stage.missing))", "((stage.missing))", []string{"stage.missing"}},
+		{"((vault:secret))", "((vault:secret))", []string{"vault:secret"}},
+		{"refs/((branch))/((tag))", "refs/release/((tag))", []string{"tag"}},
+		{map[string]interface{}{"branch": "((branch))", "depth": float64(1)}, map[string]interface{}{"branch": "release", "depth": float64(1)}, nil},
+		{[]interface{}{"((stage.region))", true}, []interface{}{"eu-de-1", true}, nil},
+	}
+	for nr, c := range cases {
+		result, _, unresolved := resolveVars(c.value, instanceVars)
+		if !reflect.DeepEqual(result, c.Result) || !reflect.DeepEqual(unresolved, c.Unresolved) {
+			t.Errorf("Test case %d failed. Got %#v, unresolved %v", nr+1, result, unresolved)
+		}
+	}
+}
+
+func TestResolveResourceVars(t *testing.T) {
+	defer func(policy string) { unresolvedVarsPolicy = policy }(unresolvedVarsPolicy)
+	pipeline := Pipeline{Name: "deploy", Team: "main", InstanceVars: atc.InstanceVars{"branch": "release"}}
+
+	cases := []struct {
+		policy string
+		source atc.Source
+		State  string
+		Keep   bool
+	}{
+		{VarsPolicyReport, atc.Source{"uri": "https://git.foo/some/repo.git"}, varsNone, true},
+		{VarsPolicyReport, atc.Source{"branch": "((branch))"}, varsResolved, true},
+		{VarsPolicyReport, atc.Source{"uri": "((repo_uri))"}, varsUnresolved, true},
+		{VarsPolicyWildcard, atc.Source{"uri": "((repo_uri))"}, varsUnresolved, true},
+		{VarsPolicySkip, atc.Source{"uri": "((repo_uri))"}, varsUnresolved, false},
+		{VarsPolicySkip, atc.Source{"branch": "((branch))"}, varsResolved, true},
+	}
+	for nr, c := range cases {
+		unresolvedVarsPolicy = c.policy
+		_, state, keep := resolveResourceVars(pipeline, atc.ResourceConfig{Name: "repo", Source: c.source})
+		if state != c.State || keep != c.Keep {
+			t.Errorf("Test case %d failed. Got %s, %t", nr+1, state, keep)
+		}
+	}
+}
+
+func TestBroadcastPushUnresolvedVars(t *testing.T) {
+	defer func(policy string) { unresolvedVarsPolicy = policy }(unresolvedVarsPolicy)
+	withResourceCache(t, Pipeline{
+		ID:   1,
+		Name: "pipeline",
+		Team: "main",
+		Resources: []atc.ResourceConfig{
+			{Name: "templated", Type: "git", WebhookToken: "t", Source: atc.Source{"uri": "https://git.foo/some/repo.git", "branch": "((branch))"}},
+		},
+	})
+	push := PushEvent{RepositoryURLs: []string{"https://git.foo/some/repo.git"}, Ref: "refs/heads/feature", DefaultBranch: "master"}
+
+	cases := []struct {
+		policy string
+		Result int
+	}{
+		{VarsPolicyReport, 0},
+		{VarsPolicyWildcard, 1},
+	}
+	for nr, c := range cases {
+		unresolvedVarsPolicy = c.policy
+		if result := BroadcastPush(NewRequestWorkqueue(1), push); result != c.Result {
+			t.Errorf("Test case %d failed. Got %d", nr+1, result)
+		}
+	}
+}
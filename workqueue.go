@@ -4,9 +4,14 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"regexp"
+	"sync"
 	"time"
 
+	"github.com/concourse/concourse/atc"
+	"github.com/concourse/concourse/go-concourse/concourse"
+
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/util/workqueue"
 
@@ -23,8 +28,15 @@ type RequestWorkqueue struct {
 	queue       workqueue.RateLimitingInterface
 	threadiness int
 
+	//api is used to check resources without webhook token and, if checkFromRef is set, from the pushed commit
+	api          *client
+	apiLock      sync.Mutex
+	checkFromRef bool
+
 	webhooksSuccess prometheus.Counter
 	webhooksErrors  prometheus.Counter
+	//checks counts the checks per pipeline instance
+	checks *prometheus.CounterVec
 }
 
 func NewRequestWorkqueue(threadiness int) *RequestWorkqueue {
@@ -42,16 +54,58 @@ func NewRequestWorkqueue(threadiness int) *RequestWorkqueue {
 			Name:      "errors_total",
 			Help:      "Total number of successfully delivered webhooks",
 		}),
+		checks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "webhook",
+			Name:      "checks_total",
+			Help:      "Total number of triggered checks per pipeline, instanced pipelines are named with their instance vars",
+		}, []string{"team", "pipeline", "result"}),
 	}
 
 	prometheus.Register(wq.webhooksSuccess)
 	prometheus.Register(wq.webhooksErrors)
+	prometheus.Register(wq.checks)
 	return wq
 
 }
 
-func (c *RequestWorkqueue) Add(url string) {
-	c.queue.Add(url)
+// checkRequest is a queued check of a resource. The webhook url is called
+// if the api isn't configured, no ref is known or the api refuses the request.
+// Resources without webhook token are always checked via the api.
+type checkRequest struct {
+	//WebhookURL is empty for resources without webhook token
+	WebhookURL string
+	Team       string
+	Pipeline   string
+	//InstanceVars are the encoded vars.* query parameters of an instanced pipeline
+	InstanceVars string
+	Resource     string
+	//Ref is the commit the check should start from
+	Ref string
+}
+
+// EnableCheckAPI makes the queue use the authenticated concourse api for resources without webhook token
+// and, if checkFromRef is set, to check resources from the pushed commit
+func (c *RequestWorkqueue) EnableCheckAPI(api *client, checkFromRef bool) {
+	c.api = api
+	c.checkFromRef = checkFromRef
+}
+
+// AddCheck queues a check of the resource, starting from the given commit if it isn't empty and checkFromRef is set
+func (c *RequestWorkqueue) AddCheck(pipeline Pipeline, resource atc.ResourceConfig, ref string) {
+	if !c.checkFromRef {
+		ref = ""
+	}
+	request := checkRequest{
+		Team:         pipeline.Team,
+		Pipeline:     pipeline.Name,
+		InstanceVars: pipeline.Ref().QueryParams().Encode(),
+		Resource:     resource.Name,
+		Ref:          ref,
+	}
+	if resource.WebhookToken != "" {
+		request.WebhookURL = webhookURL(pipeline, resource)
+	}
+	c.queue.Add(request)
 }
 
 func (c *RequestWorkqueue) Run(stopCh <-chan struct{}) {
@@ -78,11 +132,14 @@ func (c *RequestWorkqueue) processNextWorkItem() bool {
 	}
 	defer c.queue.Done(key)
 
-	err := c.perform(key.(string))
+	request := key.(checkRequest)
+	err := c.perform(request)
 	if err != nil {
 		c.webhooksErrors.Inc()
+		c.checks.WithLabelValues(request.Team, request.pipelineRef().String(), "error").Inc()
 	} else {
 		c.webhooksSuccess.Inc()
+		c.checks.WithLabelValues(request.Team, request.pipelineRef().String(), "success").Inc()
 	}
 	if err != nil && c.queue.NumRequeues(key) < 5 {
 		// Re-enqueue the key rate limited. Based on the rate limiter on the
@@ -98,7 +155,64 @@ func (c *RequestWorkqueue) processNextWorkItem() bool {
 
 var tokenRegexp = regexp.MustCompile(`webhook_token=[^&]+`)
 
-func (c *RequestWorkqueue) perform(url string) error {
+// pipelineRef returns the reference of the pipeline instance the resource belongs to
+func (r checkRequest) pipelineRef() atc.PipelineRef {
+	ref := atc.PipelineRef{Name: r.Pipeline}
+	if query, err := url.ParseQuery(r.InstanceVars); err == nil {
+		ref.InstanceVars, _ = atc.InstanceVarsFromQueryParams(query)
+	}
+	return ref
+}
+
+func (c *RequestWorkqueue) perform(request checkRequest) error {
+	if request.WebhookURL == "" {
+		if c.api == nil {
+			return fmt.Errorf("Can't check %s/%s in team %s without webhook token", request.pipelineRef(), request.Resource, request.Team)
+		}
+		return c.check(request)
+	}
+	if c.api != nil && c.checkFromRef && request.Ref != "" {
+		err := c.check(request)
+		if err == nil || (err != concourse.ErrForbidden && err != concourse.ErrUnauthorized && err != errResourceNotFound) {
+			return err
+		}
+		log.Printf("Checking %s/%s in team %s from %s via api failed, falling back to webhook: %s", request.pipelineRef(), request.Resource, request.Team, request.Ref, err)
+	}
+	return c.callWebhook(request.WebhookURL)
+}
+
+var errResourceNotFound = fmt.Errorf("Resource not found")
+
+// check triggers a check of the resource via the api. Starting from the requested commit
+// guarantees that the commit shows up as version even if newer pushes race it.
+func (c *RequestWorkqueue) check(request checkRequest) error {
+	var version atc.Version
+	if request.Ref != "" {
+		version = atc.Version{"ref": request.Ref}
+	}
+	if debug {
+		log.Printf("DRY RUN: Checking %s/%s in team %s from version %v", request.pipelineRef(), request.Resource, request.Team, version)
+		return nil
+	}
+	c.apiLock.Lock()
+	concourseClient, err := c.api.RefreshClientWithToken()
+	c.apiLock.Unlock()
+	if err != nil {
+		return fmt.Errorf("Failed to create Concourse client: %s", err)
+	}
+
+	log.Printf("Checking %s/%s in team %s from version %v", request.pipelineRef(), request.Resource, request.Team, version)
+	_, found, err := concourseClient.Team(request.Team).CheckResource(request.pipelineRef(), request.Resource, version, false)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errResourceNotFound
+	}
+	return nil
+}
+
+func (c *RequestWorkqueue) callWebhook(url string) error {
 	redactedURL := tokenRegexp.ReplaceAllString(url, "webhook_token=[REDACTED]")
 	if debug {
 		log.Printf("DRY RUN: Calling POST %s", redactedURL)
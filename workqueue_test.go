@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/concourse/concourse/atc"
+)
+
+func TestPerformCheck(t *testing.T) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		switch req.URL.Path {
+		case "/sky/issuer/token":
+			rw.Header().Set("Content-Type", "application/json")
+			io.WriteString(rw, `{"access_token":"token","token_type":"Bearer","expires_in":3600}`)
+			return
+		case "/api/v1/teams/main/pipelines/allowed/resources/repo/check":
+			if req.URL.RawQuery != "" {
+				body = append(body, " ?"+req.URL.RawQuery...)
+			}
+			calls = append(calls, "check "+string(body))
+			rw.Header().Set("Content-Type", "application/json")
+			io.WriteString(rw, `{"id":1}`)
+			return
+		case "/api/v1/teams/main/pipelines/forbidden/resources/repo/check":
+			calls = append(calls, "check "+string(body))
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		calls = append(calls, "webhook "+req.URL.Path)
+		rw.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	defer func(url string) { concourseURL = url }(concourseURL)
+	concourseURL = server.URL
+
+	api, _ := NewConcourseClient(server.URL, "user", "password")
+	resource := atc.ResourceConfig{Name: "repo", Type: "git", WebhookToken: "t"}
+
+	tokenless := atc.ResourceConfig{Name: "repo", Type: "git"}
+
+	cases := []struct {
+		pipeline     string
+		instanceVars atc.InstanceVars
+		resource     atc.ResourceConfig
+		ref          string
+		api          bool
+		checkFromRef bool
+		Calls        []string
+	}{
+		{"allowed", nil, resource, "abc", true, true, []string{`check {"from":{"ref":"abc"},"shallow":false}`}},
+		{"forbidden", nil, resource, "abc", true, true, []string{`check {"from":{"ref":"abc"},"shallow":false}`, "webhook /api/v1/teams/main/pipelines/forbidden/resources/repo/check/webhook"}},
+		{"allowed", nil, resource, "", true, true, []string{"webhook /api/v1/teams/main/pipelines/allowed/resources/repo/check/webhook"}},
+		{"allowed", nil, resource, "abc", false, false, []string{"webhook /api/v1/teams/main/pipelines/allowed/resources/repo/check/webhook"}},
+		{"allowed", nil, tokenless, "", true, true, []string{`check {"from":null,"shallow":false}`}},
+		{"forbidden", nil, tokenless, "abc", true, true, []string{`check {"from":{"ref":"abc"},"shallow":false}`}},
+		{"allowed", atc.InstanceVars{"stage": "qa"}, resource, "abc", true, true, []string{`check {"from":{"ref":"abc"},"shallow":false} ?vars.stage=%22qa%22`}},
+		//without checkFromRef the api only checks resources without webhook token and never from the pushed commit
+		{"allowed", nil, resource, "abc", true, false, []string{"webhook /api/v1/teams/main/pipelines/allowed/resources/repo/check/webhook"}},
+		{"allowed", nil, tokenless, "abc", true, false, []string{`check {"from":null,"shallow":false}`}},
+	}
+	for nr, c := range cases {
+		calls = nil
+		queue := NewRequestWorkqueue(1)
+		if c.api {
+			queue.EnableCheckAPI(api, c.checkFromRef)
+		}
+		queue.AddCheck(Pipeline{Name: c.pipeline, InstanceVars: c.instanceVars, Team: "main"}, c.resource, c.ref)
+		key, _ := queue.queue.Get()
+		//refused checks of resources without webhook token can't fall back
+		if err := queue.perform(key.(checkRequest)); (err != nil) != (c.resource.WebhookToken == "" && c.pipeline == "forbidden") {
+			t.Errorf("Test case %d failed: %v", nr+1, err)
+		}
+		if len(calls) != len(c.Calls) {
+			t.Errorf("Test case %d failed. Got %v", nr+1, calls)
+			continue
+		}
+		for i := range calls {
+			if calls[i] != c.Calls[i] {
+				t.Errorf("Test case %d failed. Got %v", nr+1, calls)
+			}
+		}
+	}
+}
+
+func TestPerformTokenlessWithoutAPI(t *testing.T) {
+	queue := NewRequestWorkqueue(1)
+	queue.AddCheck(Pipeline{Name: "pipeline", Team: "main"}, atc.ResourceConfig{Name: "repo", Type: "git"}, "")
+	key, _ := queue.queue.Get()
+	if err := queue.perform(key.(checkRequest)); err == nil {
+		t.Errorf("Expected error for resource without webhook token")
+	}
+}